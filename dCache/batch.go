@@ -0,0 +1,190 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
+	"log"
+	"sync"
+	"time"
+)
+
+// GetResult 是 GetMany 中单个 key 的结果
+type GetResult struct {
+	Key   string
+	Value ByteView
+	Err   error
+}
+
+// GetMany 一次获取多个 key, 返回的结果与 keys 一一对应
+// 未命中缓存的 key 按所有者分组, 每个远端节点只发送一次 BatchGet 请求, 各节点的请求并行进行;
+// 本节点负责的 key 通过 singleFlight 加载
+func (g *Group) GetMany(ctx context.Context, keys []string) []GetResult {
+	results := make([]GetResult, len(keys))
+	// 同一个 key 可能出现多次, 只加载一次
+	pending := make(map[string][]int)
+	for i, key := range keys {
+		results[i].Key = key
+		if key == "" {
+			results[i].Err = fmt.Errorf("key is required")
+			continue
+		}
+		g.stats.gets.Add(1)
+		if v, hit, err := g.lookupCache(key); hit {
+			results[i].Value, results[i].Err = v, err
+			continue
+		}
+		pending[key] = append(pending[key], i)
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	setResult := func(key string, value ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, i := range pending[key] {
+			results[i].Value, results[i].Err = value, err
+		}
+	}
+
+	// 按所有者分组, 不支持 BatchFetcher 的远端节点与本节点的 key 逐个加载
+	batches := make(map[BatchFetcher][]string)
+	var singles []string
+	for key := range pending {
+		if g.picker != nil {
+			if peer, ok := g.picker.Pick(key); ok {
+				if p, ok := peer.(BatchFetcher); ok {
+					batches[p] = append(batches[p], key)
+					continue
+				}
+			}
+		}
+		singles = append(singles, key)
+	}
+
+	for peer, batch := range batches {
+		wg.Add(1)
+		go func(peer BatchFetcher, batch []string) {
+			defer wg.Done()
+			g.batchLoadFromPeer(ctx, peer, batch, setResult)
+		}(peer, batch)
+	}
+	for _, key := range singles {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := g.load(ctx, key)
+			setResult(key, value, err)
+		}(key)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Err == nil || results[i].Key == "" {
+			continue
+		}
+		if stale, ok := g.staleOnError(results[i].Key, results[i].Err); ok {
+			results[i].Value, results[i].Err = stale, nil
+		}
+	}
+
+	return results
+}
+
+// batchLoadFromPeer 通过一次 BatchGet 请求从远端节点加载 keys
+// 请求失败或某个 key 加载失败时, 与 load 一样回退到本地加载
+func (g *Group) batchLoadFromPeer(ctx context.Context, peer BatchFetcher, keys []string,
+	setResult func(key string, value ByteView, err error)) {
+	var fallback []string
+	resp, err := peer.BatchFetch(ctx, &pb.BatchGetRequest{Group: g.name, Keys: keys})
+	if err != nil {
+		g.stats.peerErrors.Add(1)
+		log.Printf("[dCache] Failed to batch get %d keys from peer, %s\n", len(keys), err.Error())
+		fallback = keys
+	} else {
+		entries := make(map[string]*pb.BatchGetEntry, len(resp.GetEntries()))
+		for _, entry := range resp.GetEntries() {
+			entries[entry.GetKey()] = entry
+		}
+		for _, key := range keys {
+			entry, ok := entries[key]
+			switch {
+			case !ok:
+				g.stats.peerErrors.Add(1)
+				fallback = append(fallback, key)
+			case entry.GetNotFound():
+				g.stats.peerLoads.Add(1)
+				g.populateNegativeCache(key)
+				setResult(key, ByteView{}, fmt.Errorf("key %s: %w", key, ErrNotFound))
+			case entry.GetError() != "":
+				g.stats.peerErrors.Add(1)
+				log.Printf("[dCache] Failed to get [%s] from peer, %s\n", key, entry.GetError())
+				fallback = append(fallback, key)
+			default:
+				g.stats.peerLoads.Add(1)
+				value := ByteView{
+					b:        entry.GetValue(),
+					stale:    entry.GetStale(),
+					expireAt: expireAtFromTTL(time.Duration(entry.GetTtlNs())),
+				}
+				g.populateHotCache(key, value)
+				setResult(key, value, nil)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range fallback {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := g.loadFallback(ctx, key)
+			setResult(key, value, err)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// loadFallback 在远端节点失败后通过 singleFlight 从本地数据源加载
+func (g *Group) loadFallback(ctx context.Context, key string) (ByteView, error) {
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	view, err := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+		return g.getLocally(ctx, key)
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+
+	return view.(ByteView), nil
+}
+
+// batchResponse 将 GetMany 的结果转换为 BatchGetResponse
+func batchResponse(results []GetResult) *pb.BatchGetResponse {
+	resp := &pb.BatchGetResponse{Entries: make([]*pb.BatchGetEntry, 0, len(results))}
+	for _, r := range results {
+		entry := &pb.BatchGetEntry{Key: r.Key}
+		switch {
+		case errors.Is(r.Err, ErrNotFound):
+			entry.NotFound = true
+		case r.Err != nil:
+			entry.Error = r.Err.Error()
+		default:
+			entry.Value = r.Value.ByteSlice()
+			entry.Stale = r.Value.Stale()
+			entry.TtlNs = int64(r.Value.ttl())
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+
+	return resp
+}
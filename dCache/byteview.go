@@ -5,15 +5,55 @@
 
 package dCache
 
+import (
+	"math"
+	"time"
+)
+
 // ByteView 持有一个只读的字节数组, 表示缓存值
 type ByteView struct {
-	b []byte
+	b        []byte
+	stale    bool          // 值已过期, 因 stale-while-revalidate 或 stale-if-error 而返回
+	expireAt time.Time     // 过期时间, 零值表示永不过期
+	delta    time.Duration // 重新加载该值的耗时, 用于提前刷新, 见 WithEarlyRefresh
 }
 
 func (v ByteView) Len() int {
 	return len(v.b)
 }
 
+// Stale 表示该值已经过期, 只是在刷新期间或数据源出错时被返回
+func (v ByteView) Stale() bool {
+	return v.stale
+}
+
+// ExpireAt 返回该值的过期时间, 零值表示永不过期
+func (v ByteView) ExpireAt() time.Time {
+	return v.expireAt
+}
+
+// ttl 返回剩余的生存时间, 用于在节点间传递: 0 表示永不过期, 已过期时返回 -1
+func (v ByteView) ttl() time.Duration {
+	if v.expireAt.IsZero() {
+		return 0
+	}
+	if d := time.Until(v.expireAt); d > 0 {
+		return d
+	}
+	return -1
+}
+
+// expireAtFromTTL 是 ByteView.ttl 的逆运算
+func expireAtFromTTL(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	if ttl < 0 {
+		return time.Now()
+	}
+	return time.Now().Add(ttl)
+}
+
 // ByteSlice 返回一个拷贝, 防止缓存值被外部程序修改
 func (v ByteView) ByteSlice() []byte {
 	return cloneBytes(v.b)
@@ -29,3 +69,14 @@ func cloneBytes(b []byte) []byte {
 	copy(c, b)
 	return c
 }
+
+// refreshEarly 按 XFetch 算法决定是否在过期前提前刷新: https://cseweb.ucsd.edu/~avattani/papers/cache_stampede.pdf
+// 越接近过期时间, 重新加载的耗时越长, 返回 true 的概率越大; beta 越大越倾向于提前刷新
+func (v ByteView) refreshEarly(now time.Time, beta float64) bool {
+	if v.expireAt.IsZero() || v.delta <= 0 || beta <= 0 {
+		return false
+	}
+	// -log(r) 服从指数分布, r 取值 (0, 1]
+	gap := time.Duration(float64(v.delta) * beta * -math.Log(1-earlyRefreshRand()))
+	return !now.Add(gap).Before(v.expireAt)
+}
@@ -6,14 +6,56 @@
 package dCache
 
 import (
-	"github.com/Daz-3ux/dCache/lru"
+	"github.com/Daz-3ux/dazCache/dCache/lru"
+	"hash/maphash"
+	"runtime"
 	"sync"
+	"time"
 )
 
+// cache 由多个按 key 哈希分区的 cacheShard 组成, 每个分区有独立的锁与 lru, 平分 cacheBytes
+// 配置字段在第一次使用前设置, 之后不再修改
 type cache struct {
+	cacheBytes int64
+	ttl        time.Duration             // 默认的生存时间, 为 0 时永不过期
+	grace      time.Duration             // 过期后继续保留的时间, 见 lru.Cache.Grace
+	newPolicy  func() lru.EvictionPolicy // 为 nil 时使用 lru 默认的淘汰策略
+	// newAdmission 为 nil 时新记录总是被保存
+	newAdmission func() lru.AdmissionPolicy
+	nshards      int           // 分区数, 为 0 时由 defaultShards 决定
+	k            int           // 默认淘汰策略 LRU-K 的 K
+	idle         time.Duration // 空闲过期时间, 见 lru.Cache.IdleTTL
+	jitter       float64       // 默认 TTL 随机缩短的最大比例, 见 lru.Cache.TTLJitter
+	maxEntries   int           // 全部分区的最大条目数之和, 为 0 时不限制
+	// onEvicted 在记录被淘汰, 过期或删除时调用, 调用时持有分区的锁
+	onEvicted func(key string, value ByteView)
+
+	once   sync.Once
+	seed   maphash.Seed
+	shards []*cacheShard
+}
+
+// cacheShard 是 cache 的一个分区
+type cacheShard struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
-	cacheBytes int64
+	cfg        *cache
+	maxBytes   int64 // 该分区的容量
+	maxEntries int   // 该分区的最大条目数
+	nevict     int64 // 已释放的 lru 中因容量不足被淘汰的条目数, 见 clear
+}
+
+// minShardBytes 是默认分区方式下每个分区的最小容量
+// 分区只能保存不超过其容量的值, 容量太小的分区既存不下较大的值, 也会使淘汰过于频繁
+const minShardBytes = 8 << 20
+
+// defaultShards 返回默认的分区数: 不超过 GOMAXPROCS 的 4 倍, 且每个分区至少有 minShardBytes 的容量
+func defaultShards(cacheBytes int64) int {
+	n := 4 * runtime.GOMAXPROCS(0)
+	if cacheBytes > 0 {
+		n = int(min(int64(n), cacheBytes/minShardBytes))
+	}
+	return max(n, 1)
 }
 
 func newCache(cacheBytes int64) *cache {
@@ -22,31 +64,240 @@ func newCache(cacheBytes int64) *cache {
 	}
 }
 
-func (c *cache) add(key string, value ByteView) {
+// shardList 在第一次使用时按当前配置创建分区
+func (c *cache) shardList() []*cacheShard {
+	c.once.Do(func() {
+		n := c.nshards
+		if n <= 0 {
+			n = defaultShards(c.cacheBytes)
+		}
+		if c.maxEntries > 0 {
+			// 每个分区至少能保存一条记录
+			n = min(n, c.maxEntries)
+		}
+		c.seed = maphash.MakeSeed()
+		c.shards = make([]*cacheShard, n)
+		for i := range c.shards {
+			// 容量为 0 表示不限制, 余数分给前几个分区
+			maxBytes := c.cacheBytes / int64(n)
+			if int64(i) < c.cacheBytes%int64(n) {
+				maxBytes++
+			}
+			maxEntries := c.maxEntries / n
+			if i < c.maxEntries%n {
+				maxEntries++
+			}
+			c.shards[i] = &cacheShard{cfg: c, maxBytes: maxBytes, maxEntries: maxEntries}
+		}
+	})
+	return c.shards
+}
+
+func (c *cache) shard(key string) *cacheShard {
+	shards := c.shardList()
+	if len(shards) == 1 {
+		return shards[0]
+	}
+	return shards[maphash.String(c.seed, key)%uint64(len(shards))]
+}
+
+// add 使用默认的 TTL 添加一条加载得到的记录, 返回其过期时间
+// cost 为重新加载该值的代价, 为 0 时表示未知; 缓存已满且设置了准入策略时, 记录可能不会被保存
+func (c *cache) add(key string, value ByteView, cost time.Duration) time.Time {
+	return c.shard(key).add(key, value, cost)
+}
+
+// addWithTTL 添加一条指定生存时间的记录, 返回其过期时间, 用于 Set 写入的值, 不经过准入策略
+func (c *cache) addWithTTL(key string, value ByteView, ttl time.Duration) time.Time {
+	return c.shard(key).addWithTTL(key, value, ttl)
+}
+
+// addBefore 与 add 相同, 但过期时间不会晚于 limit, limit 为零值时不限制
+func (c *cache) addBefore(key string, value ByteView, ttl time.Duration, limit time.Time) time.Time {
+	return c.shard(key).addBefore(key, value, ttl, limit)
+}
+
+// addWithExpire 添加一条加载得到的记录并指定过期时间, expireAt 为零值表示永不过期
+func (c *cache) addWithExpire(key string, value ByteView, expireAt time.Time, cost time.Duration) {
+	c.shard(key).addWithExpire(key, value, expireAt, cost)
+}
+
+// get 返回未过期的记录, 返回值中带有过期时间
+func (c *cache) get(key string) (ByteView, bool) {
+	return c.shard(key).get(key)
+}
+
+// getWithExpire 返回记录及其过期时间, 包括处于保留期内的已过期记录
+func (c *cache) getWithExpire(key string) (ByteView, time.Time, bool) {
+	return c.shard(key).getWithExpire(key)
+}
+
+func (c *cache) delete(key string) bool {
+	return c.shard(key).delete(key)
+}
+
+// clear 释放全部缓存
+func (c *cache) clear() {
+	for _, s := range c.shardList() {
+		s.clear()
+	}
+}
+
+// stats 返回全部分区已使用的内存, 条目数与因容量不足被淘汰的条目数之和
+func (c *cache) stats() (bytes, items, evictions int64) {
+	for _, s := range c.shardList() {
+		b, i, e := s.stats()
+		bytes, items, evictions = bytes+b, items+i, evictions+e
+	}
+	return
+}
+
+// cacheEntry 是 entries 与 restore 使用的一条记录
+type cacheEntry struct {
+	key      string
+	value    ByteView
+	expireAt time.Time
+}
+
+// entries 返回全部未过期的记录, 每个分区内按淘汰顺序排列
+// 依次 restore 这些记录即可还原每个分区内的淘汰顺序
+func (c *cache) entries() []cacheEntry {
+	var entries []cacheEntry
+	for _, s := range c.shardList() {
+		entries = append(entries, s.entries()...)
+	}
+	return entries
+}
+
+// restore 依次添加 entries 中的记录, 已存在的 key 保留当前的值
+func (c *cache) restore(entries []cacheEntry) {
+	for _, e := range entries {
+		c.shard(e.key).restore(e)
+	}
+}
+
+// add 使用默认的 TTL 添加一条加载得到的记录, 返回其过期时间
+// cost 为重新加载该值的代价, 为 0 时表示未知; 缓存已满且设置了准入策略时, 记录可能不会被保存
+func (c *cacheShard) add(key string, value ByteView, cost time.Duration) time.Time {
+	return c.put(key, value, 0, time.Time{}, true, cost)
+}
+
+// addWithTTL 添加一条指定生存时间的记录, 返回其过期时间, 用于 Set 写入的值, 不经过准入策略
+// ttl <= 0 时使用 cache.ttl, 为 0 时永不过期
+func (c *cacheShard) addWithTTL(key string, value ByteView, ttl time.Duration) time.Time {
+	return c.put(key, value, ttl, time.Time{}, false, 0)
+}
+
+// addBefore 与 add 相同, 但过期时间不会晚于 limit, limit 为零值时不限制
+// 用于保存从远端节点获取的值, 以免延长所有者设置的过期时间
+func (c *cacheShard) addBefore(key string, value ByteView, ttl time.Duration, limit time.Time) time.Time {
+	return c.put(key, value, ttl, limit, true, 0)
+}
+
+// put 计算过期时间并添加记录, admit 为 true 时新记录需要经过准入策略
+// cost 为 0 时使用 value 中记录的加载耗时, 仍为 0 时由淘汰策略按代价未知处理
+func (c *cacheShard) put(key string, value ByteView, ttl time.Duration, limit time.Time, admit bool, cost time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lazyInit()
+
+	if ttl <= 0 {
+		// cache.ttl 已作为 lru 的 TTL, 默认的 TTL 会随机缩短, 见 lru.Cache.TTLJitter
+		ttl = c.lru.NextTTL()
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	if !limit.IsZero() && (expireAt.IsZero() || limit.Before(expireAt)) {
+		expireAt = limit
+	}
+	if cost <= 0 {
+		cost = value.delta
+	}
+	if admit {
+		c.lru.AddIfAdmitted(key, value, expireAt, cost.Seconds())
+	} else {
+		c.lru.AddWithExpire(key, value, expireAt)
+		c.lru.SetCost(key, cost.Seconds())
+	}
+	// 开启空闲过期时, 实际的过期时间可能早于 expireAt
+	if at, ok := c.lru.ExpireAt(key); ok {
+		expireAt = at
+	}
+
+	return expireAt
+}
+
+// addWithExpire 添加一条加载得到的记录并指定过期时间, expireAt 为零值表示永不过期
+func (c *cacheShard) addWithExpire(key string, value ByteView, expireAt time.Time, cost time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	// 延迟初始化: 在第一次用到 lru 时才初始化
+	c.lazyInit()
+	if cost <= 0 {
+		cost = value.delta
+	}
+	c.lru.AddIfAdmitted(key, value, expireAt, cost.Seconds())
+}
+
+// options 返回创建分区的 lru 使用的配置, 淘汰策略与回调除外
+func (c *cache) options() lru.Options {
+	return lru.Options{MaxBytes: c.cacheBytes, MaxEntries: c.maxEntries, K: c.k, TTL: c.ttl, IdleTTL: c.idle, TTLJitter: c.jitter}
+}
+
+// lazyInit 延迟初始化: 在第一次用到 lru 时才初始化, 调用方需持有 c.mu
+func (c *cacheShard) lazyInit() {
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		opts := c.cfg.options()
+		opts.MaxBytes, opts.MaxEntries = c.maxBytes, c.maxEntries
+		if c.cfg.newPolicy != nil {
+			opts.Policy = c.cfg.newPolicy()
+		}
+		if c.cfg.onEvicted != nil {
+			opts.OnEvicted = func(key string, value lru.Value) {
+				c.cfg.onEvicted(key, value.(ByteView))
+			}
+		}
+		var err error
+		// 配置已在 newGroup 中检查过
+		if c.lru, err = lru.New(opts); err != nil {
+			panic(err)
+		}
+		c.lru.Grace = c.cfg.grace
+		if c.cfg.newAdmission != nil {
+			c.lru.Admission = c.cfg.newAdmission()
+		}
 	}
-	c.lru.Add(key, value)
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+// get 返回未过期的记录, 返回值中带有过期时间
+func (c *cacheShard) get(key string) (value ByteView, ok bool) {
+	value, expireAt, ok := c.getWithExpire(key)
+	if ok && !expireAt.IsZero() && !time.Now().Before(expireAt) {
+		return ByteView{}, false
+	}
+
+	return value, ok
+}
+
+// getWithExpire 返回记录及其过期时间, 包括处于保留期内的已过期记录
+func (c *cacheShard) getWithExpire(key string) (value ByteView, expireAt time.Time, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
 		return
 	}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	if v, expireAt, ok := c.lru.GetWithExpire(key); ok {
+		value = v.(ByteView)
+		value.expireAt = expireAt
+		return value, expireAt, ok
 	}
 
 	return
 }
 
-func (c *cache) delete(key string) (ok bool) {
+func (c *cacheShard) delete(key string) (ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
@@ -59,3 +310,55 @@ func (c *cache) delete(key string) (ok bool) {
 
 	return false
 }
+
+// clear 释放全部缓存
+func (c *cacheShard) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.nevict += c.lru.Evictions()
+	}
+	c.lru = nil
+}
+
+// stats 返回已使用的内存, 条目数与因容量不足被淘汰的条目数
+func (c *cacheShard) stats() (bytes, items, evictions int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0, 0, c.nevict
+	}
+
+	return c.lru.Bytes(), int64(c.lru.Len()), c.nevict + c.lru.Evictions()
+}
+
+// entries 返回全部未过期的记录, 按淘汰顺序排列
+func (c *cacheShard) entries() []cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+
+	now := time.Now()
+	entries := make([]cacheEntry, 0, c.lru.Len())
+	c.lru.Walk(func(key string, value lru.Value, expireAt time.Time) {
+		if expireAt.IsZero() || now.Before(expireAt) {
+			entries = append(entries, cacheEntry{key, value.(ByteView), expireAt})
+		}
+	})
+
+	return entries
+}
+
+// restore 添加一条记录, 已存在的 key 保留当前的值
+func (c *cacheShard) restore(e cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lazyInit()
+
+	if !c.lru.Contains(e.key) {
+		c.lru.AddWithExpire(e.key, e.value, e.expireAt)
+		c.lru.SetCost(e.key, e.value.delta.Seconds())
+	}
+}
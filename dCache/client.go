@@ -7,23 +7,99 @@ package dCache
 
 import (
 	"context"
+	"fmt"
 	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
 	"github.com/Daz-3ux/dazCache/dCache/register"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"time"
 )
 
+// defaultFetchTimeout 是调用方未设置截止时间时, 一次远端请求的超时时间
+const defaultFetchTimeout = 10 * time.Second
+
 // client 模块实现 dCache 访问其他节点获取缓存能力
 type client struct {
-	name string // 服务名称: dCache/ip:port
+	name    string     // 服务名称: dCache/ip:port
+	latency *histogram // Fetch 请求的耗时
 }
 
 func (c *client) Fetch(group string, key string) ([]byte, error) {
+	resp, err := c.FetchContext(context.Background(), &pb.DCacheRequest{Group: group, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetValue(), nil
+}
+
+// FetchContext 携带调用方的 ctx 访问远端节点, ctx 没有截止时间时使用 defaultFetchTimeout
+func (c *client) FetchContext(ctx context.Context, in *pb.DCacheRequest) (*pb.DCacheResponse, error) {
+	var resp *pb.DCacheResponse
+	start := time.Now()
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) (err error) {
+		resp, err = grpcClient.Get(ctx, in)
+		return
+	})
+	if c.latency != nil {
+		c.latency.observe(time.Since(start))
+	}
+	// 将远端节点的 NotFound 还原为 ErrNotFound
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("key %s: %w", in.GetKey(), ErrNotFound)
+	}
+
+	return resp, err
+}
+
+// Put 将缓存值写入远端节点
+func (c *client) Put(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	var resp *pb.SetResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) (err error) {
+		resp, err = grpcClient.Set(ctx, in)
+		return
+	})
+
+	return resp, err
+}
+
+// BatchFetch 一次请求从远端节点获取多个 key
+func (c *client) BatchFetch(ctx context.Context, in *pb.BatchGetRequest) (*pb.BatchGetResponse, error) {
+	var resp *pb.BatchGetResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) (err error) {
+		resp, err = grpcClient.BatchGet(ctx, in)
+		return
+	})
+
+	return resp, err
+}
+
+// Invalidate 使远端节点上的缓存失效
+func (c *client) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	var resp *pb.InvalidateResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) (err error) {
+		resp, err = grpcClient.Invalidate(ctx, in)
+		return
+	})
+
+	return resp, err
+}
+
+// call 通过 etcd 发现远端节点并建立连接, 然后调用 fn 发起 RPC 请求
+// ctx 没有截止时间时使用 defaultFetchTimeout
+func (c *client) call(ctx context.Context, fn func(ctx context.Context, grpcClient pb.GroupCacheClient) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultFetchTimeout)
+		defer cancel()
+	}
+
 	// 创建一个 etcd 客户端
 	cli, err := clientv3.New(register.DefaultEtcdConfig)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func(cli *clientv3.Client) {
 		err := cli.Close()
@@ -33,9 +109,9 @@ func (c *client) Fetch(group string, key string) ([]byte, error) {
 	}(cli)
 
 	// 服务发现
-	conn, err := register.EtcdDial(cli, c.name)
+	conn, err := register.EtcdDialContext(ctx, cli, c.name)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func(conn *grpc.ClientConn) {
 		err := conn.Close()
@@ -44,14 +120,5 @@ func (c *client) Fetch(group string, key string) ([]byte, error) {
 		}
 	}(conn)
 
-	grpcClient := pb.NewGroupCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	resp, err := grpcClient.Get(ctx, &pb.DCacheRequest{Group: group, Key: key})
-	if err != nil {
-		return nil, err
-	}
-
-	return []byte(resp.GetValue()), nil
+	return fn(ctx, pb.NewGroupCacheClient(conn))
 }
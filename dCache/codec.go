@@ -0,0 +1,77 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+)
+
+// Codec 负责缓存值与字节数组之间的转换
+// Unmarshal 的 v 为指向目标值的指针
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec 使用 encoding/json 编解码
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec 使用 encoding/gob 编解码, 每个值独立编码, 包含完整的类型信息
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec 使用 protobuf 编解码, 值的类型必须实现 proto.Message
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("dCache: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// TypedGroup[*pb.Msg] 传入的是 **pb.Msg, 需要先分配消息
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Pointer {
+			msg := reflect.New(rv.Elem().Type().Elem())
+			if m, ok = msg.Interface().(proto.Message); ok {
+				rv.Elem().Set(msg)
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("dCache: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -6,10 +6,16 @@
 package dCache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
+	"github.com/Daz-3ux/dazCache/dCache/lru"
 	"github.com/Daz-3ux/dazCache/dCache/singleFlight"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 /*
@@ -35,6 +41,10 @@ if err != nil {
 灵活 -- 适配 -- 可插拔
 */
 
+// ErrNotFound 表示数据源中不存在指定的 key
+// Getter 返回的错误包装了 ErrNotFound 时, Group 可以对其进行负缓存, 见 WithNegativeCache
+var ErrNotFound = errors.New("not found")
+
 // Getter 是一个加载指定 key 的数据的接口
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -48,77 +58,592 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// GetterWithContext 是携带 context 加载指定 key 的数据的接口
+// 调用方的截止时间与取消信号会通过 ctx 传递给数据源
+type GetterWithContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterWithContextFunc 是一个通过函数实现 GetterWithContext 接口的类型
+// 它同时实现了 Getter, 因此可以直接传给 NewGroup
+type GetterWithContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext 实现了 GetterWithContext 接口的函数
+func (f GetterWithContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get 实现了 Getter 接口的函数, 使用 context.Background()
+func (f GetterWithContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// Entry 是数据源返回的带有元数据的值
+type Entry struct {
+	Value    []byte
+	ExpireAt time.Time     // 该值的过期时间, 零值表示使用 Group 默认的 TTL
+	Cost     time.Duration // 重新加载该值的代价, 零值表示使用本次加载的耗时, 以秒为单位传给 lru.CostPolicy
+	Pin      bool          // 固定该值, 使其不会被淘汰也不会过期, 见 Group.Pin; 超出 WithPinnedBytes 的容量时按普通的值缓存
+}
+
+// EntryGetter 是可以为每个值指定过期时间的 Getter
+// 适用于知道每条记录有效期的数据源, 例如 HTTP 的 Cache-Control 或数据库中的字段
+type EntryGetter interface {
+	GetEntry(ctx context.Context, key string) (Entry, error)
+}
+
+// EntryGetterFunc 是一个通过函数实现 EntryGetter 接口的类型
+// 它同时实现了 Getter 与 GetterWithContext, 因此可以直接传给 NewGroup
+type EntryGetterFunc func(ctx context.Context, key string) (Entry, error)
+
+// GetEntry 实现了 EntryGetter 接口的函数
+func (f EntryGetterFunc) GetEntry(ctx context.Context, key string) (Entry, error) {
+	return f(ctx, key)
+}
+
+// GetContext 实现了 GetterWithContext 接口的函数, 忽略过期时间
+func (f EntryGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	entry, err := f(ctx, key)
+	return entry.Value, err
+}
+
+// Get 实现了 Getter 接口的函数, 使用 context.Background()
+func (f EntryGetterFunc) Get(key string) ([]byte, error) {
+	return f.GetContext(context.Background(), key)
+}
+
+// entryGetterAdapter 将 GetterWithContext 适配为 EntryGetter, 值使用 Group 默认的 TTL
+type entryGetterAdapter struct {
+	GetterWithContext
+}
+
+func (a entryGetterAdapter) GetEntry(ctx context.Context, key string) (Entry, error) {
+	bytes, err := a.GetContext(ctx, key)
+	return Entry{Value: bytes}, err
+}
+
+// getterAdapter 将不支持 context 的 Getter 适配为 GetterWithContext
+type getterAdapter struct {
+	Getter
+}
+
+func (a getterAdapter) GetContext(ctx context.Context, key string) ([]byte, error) {
+	// Getter 无法被中途取消, 只能在调用前检查 ctx
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.Get(key)
+}
+
 // Group 是 GeeCache 最核心的数据结构，负责与外部交互，控制缓存存储和获取的主流程
 type Group struct {
 	name      string
-	getter    Getter
-	mainCache cache
+	getter    EntryGetter
+	mainCache cache // 本节点作为所有者的缓存
+	hotCache  cache // 从远端节点获取的热点值的副本, 避免每次请求都访问远端节点
+	negCache  cache // 不存在的 key, 只记录 key 本身, 不占用 mainCache 的容量
 	picker    Picker
 	loader    *singleFlight.Group
+	stats     groupStats
+
+	staleWhileRevalidate time.Duration // 过期后仍可直接返回旧值的时间, 同时在后台刷新
+	staleIfError         time.Duration // 过期后在数据源或远端节点出错时仍可返回旧值的时间
+	refreshing           sync.Map      // 正在后台刷新的 key
+	shards               int           // mainCache 与 hotCache 的分区数, 为 0 时根据容量决定
+	earlyRefresh         float64       // XFetch 提前刷新的 beta, <= 0 时关闭
+
+	writer storeWriter // 将 Set 与 Delete 写回数据源, 为 nil 时只修改缓存
+	pins   pinStore    // 被固定的 key 及其值, 见 Pin
+	err    error       // GroupOption 中的配置错误, 由 NewGroup 返回
+
+	expiryInterval time.Duration // 主动过期的间隔, <= 0 时关闭
+	janitor        *janitor      // 主动过期的后台任务, 关闭时为 nil
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// GroupOption 是 NewGroup 的可选配置
+type GroupOption func(*Group)
 
-// NewGroup 创建一个新的 Group 实例
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	if getter == nil {
-		panic("nil Getter")
+// WithHotCache 设置 hotCache 的容量与生存时间, maxBytes 为 0 时不再保存远端节点的值
+// 默认容量为 mainCache 的 1/8; ttl 为 0 时生存时间与 mainCache 相同
+func WithHotCache(maxBytes int64, ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.hotCache = cache{cacheBytes: maxBytes, ttl: ttl}
 	}
-	mu.Lock()
-	defer mu.Unlock()
+}
 
-	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleFlight.Group{},
+// WithNegativeCache 开启负缓存: 数据源返回 ErrNotFound 的 key 在 ttl 内直接返回 ErrNotFound
+// 负缓存只记录 key, 使用独立的容量 maxBytes, 默认关闭; maxBytes 大于 0 时 ttl 必须大于 0, 否则 key 永远不会被重新加载
+func WithNegativeCache(maxBytes int64, ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negCache = cache{cacheBytes: maxBytes, ttl: ttl}
+	}
+}
+
+// WithStaleWhileRevalidate 开启 stale-while-revalidate: mainCache 中的值过期后的 window 内,
+// Get 立即返回旧值, 并通过 singleFlight 在后台刷新一次
+func WithStaleWhileRevalidate(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleWhileRevalidate = window
+	}
+}
+
+// WithStaleIfError 开启 stale-if-error: 值过期后的 maxStale 内, 如果数据源或远端节点加载失败,
+// Get 返回旧值而不是错误, 返回值的 Stale() 为 true; 数据源返回 ErrNotFound 时不会返回旧值
+func WithStaleIfError(maxStale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleIfError = maxStale
+	}
+}
+
+// WithTTL 设置 mainCache 中加载得到的值的生存时间, 默认永不过期
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.ttl = ttl
+	}
+}
+
+// WithIdleTTL 开启空闲过期: mainCache 中的值在 idle 内没有被命中就会过期, 每次命中都会延长过期时间
+// WithTTL 设置的生存时间或 Getter 返回的 Entry.ExpireAt 仍然是最长的生存时间, 两者都未设置时只按空闲时间过期
+func WithIdleTTL(idle time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.idle = idle
+	}
+}
+
+// WithTTLJitter 将 mainCache 默认的 TTL 随机缩短至多 fraction 的比例, fraction 取值 [0, 1)
+// 同时加载的值因此不会在同一时刻过期, 避免集中的重新加载; Getter 返回的 Entry.ExpireAt 与 Set 指定的 TTL 不受影响
+func WithTTLJitter(fraction float64) GroupOption {
+	return func(g *Group) {
+		g.mainCache.jitter = fraction
+	}
+}
+
+// WithEarlyRefresh 开启 XFetch 提前刷新: mainCache 中的值在过期前被命中时, 以随着临近过期而增大的概率
+// 通过 singleFlight 在后台刷新一次, 调用方立即得到当前的值; beta 通常为 1, 越大越早刷新, <= 0 时关闭
+// 概率同时取决于上一次加载的耗时, 见 Entry.Cost
+func WithEarlyRefresh(beta float64) GroupOption {
+	return func(g *Group) {
+		g.earlyRefresh = beta
+	}
+}
+
+// WithK 设置 mainCache 默认淘汰策略 LRU-K 的 K, 默认为 1; 设置了 WithEvictionPolicy 时无效
+func WithK(k int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.k = k
+	}
+}
+
+// WithMaxEntries 限制 mainCache 的条目数, 默认只限制容量
+// 条目数与容量一样平分给各个分区, 分区数不会超过 n
+func WithMaxEntries(n int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.maxEntries = n
+	}
+}
+
+// WithOnEvicted 设置 mainCache 中的记录被淘汰, 过期或删除时的回调
+// 回调在持有缓存分区的锁时同步调用, 不能再访问同一个 Group
+func WithOnEvicted(fn func(key string, value ByteView)) GroupOption {
+	return func(g *Group) {
+		g.mainCache.onEvicted = fn
+	}
+}
+
+// WithConfig 使用 lru.LoadConfig 读取的配置设置 mainCache 的 K, TTL, 空闲过期时间, TTL 抖动与最大条目数
+// cfg.MaxBytes 不为 0 时代替 NewGroup 的 cacheBytes; 配置不合法时 NewGroup 返回错误
+func WithConfig(cfg lru.Config) GroupOption {
+	return func(g *Group) {
+		opts, err := cfg.Options()
+		if err != nil {
+			g.err = err
+			return
+		}
+		if opts.MaxBytes != 0 {
+			g.mainCache.cacheBytes = opts.MaxBytes
+		}
+		g.mainCache.k, g.mainCache.ttl, g.mainCache.maxEntries = opts.K, opts.TTL, opts.MaxEntries
+		g.mainCache.idle, g.mainCache.jitter = opts.IdleTTL, opts.TTLJitter
+	}
+}
+
+// WithEvictionPolicy 设置 mainCache 的淘汰策略, 例如 lru.NewLFU 或 lru.NewGreedyDualSize; 默认使用 LRU-K, 见 WithK
+// newPolicy 在每次创建缓存时调用, 返回的实例不能被共享
+func WithEvictionPolicy(newPolicy func() lru.EvictionPolicy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = newPolicy
+	}
+}
+
+// WithAdmission 为 mainCache 设置准入策略, 例如 TinyLFU: func() lru.AdmissionPolicy { return lru.NewTinyLFU(n) }
+// 新加载的值先进入约占容量 1% 的准入窗口 (W-TinyLFU), 离开窗口时缓存已满则只有比淘汰对象更常被访问才会被保存,
+// 以免一次性扫描冲掉热点数据, 突然变热的新 key 也可以在窗口中积累访问次数; Set 写入的值不受影响
+func WithAdmission(newAdmission func() lru.AdmissionPolicy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newAdmission = newAdmission
+	}
+}
+
+// WithShards 将 mainCache 与 hotCache 分别划分为 n 个分区, 每个分区有独立的锁并平分容量
+// 默认的分区数随 GOMAXPROCS 增加, 但每个分区至少有 8MB 的容量
+// 超过一个分区容量的值不会被缓存, 值较大时应减少分区数, 例如 WithShards(1)
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.shards = n
+	}
+}
+
+// backgroundRefreshTimeout 是一次后台刷新的超时时间
+const backgroundRefreshTimeout = 10 * time.Second
+
+// earlyRefreshRand 返回 [0, 1) 的随机数, 用于 XFetch 提前刷新
+var earlyRefreshRand = rand.Float64
+
+// hotCacheSample 决定一个从远端节点获取的值是否放入 hotCache, 默认随机保存 1/10
+var hotCacheSample = func() bool {
+	return rand.Intn(10) == 0
+}
+
+// NewGroup 在 DefaultRegistry 中创建一个新的 Group 实例, 同名的 Group 会被替换, 配置不合法时 panic
+// 需要在名称重复时返回错误请使用 Registry.NewGroup
+// 如果 getter 同时实现了 GetterWithContext, 加载数据时优先使用 GetContext
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
+	g, err := DefaultRegistry.replaceGroup(name, cacheBytes, getter, opts...)
+	if err != nil {
+		panic(err)
 	}
-	groups[name] = g
 
 	return g
 }
 
-// GetGroup 返回指定名称的 Group
+// GetGroup 返回 DefaultRegistry 中指定名称的 Group
 func GetGroup(name string) *Group {
-	mu.RLock()
-	defer mu.RUnlock()
+	return DefaultRegistry.GetGroup(name)
+}
 
-	g := groups[name]
+// newGroup 根据配置创建 Group, 不会将其注册到任何 Registry, 配置不合法时返回错误
+func newGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	var entryGetter EntryGetter
+	switch getter := getter.(type) {
+	case EntryGetter:
+		entryGetter = getter
+	case GetterWithContext:
+		entryGetter = entryGetterAdapter{getter}
+	default:
+		entryGetter = entryGetterAdapter{getterAdapter{getter}}
+	}
 
-	return g
+	g := &Group{
+		name:      name,
+		getter:    entryGetter,
+		mainCache: cache{cacheBytes: cacheBytes},
+		hotCache:  cache{cacheBytes: cacheBytes / 8},
+		loader:    &singleFlight.Group{},
+
+		expiryInterval: defaultExpiryInterval,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.hotCache.ttl == 0 {
+		g.hotCache.ttl = g.mainCache.ttl
+	}
+	// 过期的值需要在 mainCache 中多保留一段时间, hotCache 中的副本只用于 stale-if-error
+	g.mainCache.grace = max(g.staleWhileRevalidate, g.staleIfError)
+	g.hotCache.grace = g.staleIfError
+	g.mainCache.nshards, g.hotCache.nshards = g.shards, g.shards
+	if err := g.validate(); err != nil {
+		if g.writer != nil {
+			g.writer.close()
+		}
+		return nil, fmt.Errorf("group %s: %w", name, err)
+	}
+	if g.expiryInterval > 0 {
+		g.janitor = startJanitor(g.expiryInterval, &g.mainCache, &g.hotCache, &g.negCache)
+	}
+
+	return g, nil
+}
+
+// validate 检查 GroupOption 设置的配置
+func (g *Group) validate() error {
+	if g.err != nil {
+		return g.err
+	}
+	if g.shards < 0 {
+		return fmt.Errorf("negative shards %d", g.shards)
+	}
+	if g.pins.maxBytes < 0 {
+		return fmt.Errorf("negative pinned bytes %d", g.pins.maxBytes)
+	}
+	if g.negCache.cacheBytes > 0 && g.negCache.ttl <= 0 {
+		return fmt.Errorf("negative cache requires a positive TTL, but %s got", g.negCache.ttl)
+	}
+	for _, c := range []*cache{&g.mainCache, &g.hotCache, &g.negCache} {
+		if err := c.options().Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Get 从缓存中获取指定 key 的数据
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同, 但 ctx 的截止时间与取消信号会贯穿 singleFlight 等待, 远端节点请求与数据源加载
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	g.stats.gets.Add(1)
 
-	// 从 mainCache 中查找缓存，如果存在则返回缓存值
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[dCache] hit")
-		return v, nil
+	// 从缓存中查找，如果存在则返回缓存值
+	if v, hit, err := g.lookupCache(key); hit {
+		return v, err
 	}
 
 	// 如果缓存不存在，则调用 load 方法加载
-	return g.load(key)
+	value, err := g.load(ctx, key)
+	if err != nil {
+		if stale, ok := g.staleOnError(key, err); ok {
+			return stale, nil
+		}
+	}
+	return value, err
+}
+
+// staleOnError 在加载失败时查找 stale-if-error 保留期内的旧值
+func (g *Group) staleOnError(key string, err error) (ByteView, bool) {
+	if g.staleIfError <= 0 || errors.Is(err, ErrNotFound) {
+		return ByteView{}, false
+	}
+
+	for _, c := range []*cache{&g.mainCache, &g.hotCache} {
+		v, expireAt, ok := c.getWithExpire(key)
+		if !ok || expireAt.IsZero() || time.Since(expireAt) > g.staleIfError {
+			continue
+		}
+		log.Printf("[dCache] Failed to load [%s], serving stale value, %s\n", key, err.Error())
+		g.stats.staleHits.Add(1)
+		v.stale = time.Now().After(expireAt)
+		return v, true
+	}
+
+	return ByteView{}, false
 }
 
+// lookupCache 依次查找被固定的值, mainCache, hotCache 与负缓存, hit 为 false 时需要加载
+func (g *Group) lookupCache(key string) (value ByteView, hit bool, err error) {
+	if v, ok := g.pins.get(key); ok {
+		log.Println("[dCache] pinned hit")
+		g.stats.cacheHits.Add(1)
+		return v, true, nil
+	}
+	if v, expireAt, ok := g.mainCache.getWithExpire(key); ok {
+		if now := time.Now(); expireAt.IsZero() || now.Before(expireAt) {
+			log.Println("[dCache] hit")
+			g.stats.cacheHits.Add(1)
+			if v.refreshEarly(now, g.earlyRefresh) {
+				g.stats.earlyRefreshes.Add(1)
+				g.refreshInBackground(key)
+			}
+			return v, true, nil
+		}
+		if time.Since(expireAt) <= g.staleWhileRevalidate {
+			log.Println("[dCache] stale hit, revalidating")
+			g.stats.staleHits.Add(1)
+			g.refreshInBackground(key)
+			v.stale = true
+			return v, true, nil
+		}
+	}
+	if v, ok := g.hotCache.get(key); ok {
+		log.Println("[dCache] hot cache hit")
+		g.stats.cacheHits.Add(1)
+		return v, true, nil
+	}
+	if _, ok := g.negCache.get(key); ok {
+		log.Println("[dCache] negative cache hit")
+		g.stats.negativeHits.Add(1)
+		return ByteView{}, true, fmt.Errorf("key %s: %w", key, ErrNotFound)
+	}
+
+	return ByteView{}, false, nil
+}
+
+// SetOptions 是 Group.Set 的可选参数
+type SetOptions struct {
+	TTL time.Duration // 该值的生存时间, 为 0 时使用缓存默认的 TTL
+}
+
+// Set 将 key 对应的值写入其所有者节点, 之后对该 key 的读取都会得到新值
+// 所有者为本节点时直接写入 mainCache; 写入远端节点失败时不会回退到本地, 以免各节点的值不一致
+// 开启了 write-through 或 write-behind 时, 所有者节点还会将值写回数据源
+func (g *Group) Set(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	if g.picker != nil {
+		if peer, ok := g.picker.Pick(key); ok {
+			p, ok := peer.(Putter)
+			if !ok {
+				return fmt.Errorf("peer of key %s does not support Set", key)
+			}
+			_, err := p.Put(ctx, &pb.SetRequest{Group: g.name, Key: key, Value: value, TtlNs: int64(opts.TTL)})
+			if err != nil {
+				return err
+			}
+			// 本节点与其它节点可能缓存过该 key, 删除以免读到旧值
+			g.deleteCache(key)
+			g.logInvalidateErrors(key, g.invalidatePeers(ctx, key, peer))
+			return nil
+		}
+	}
+
+	if err := g.setOnOwner(ctx, key, value, opts.TTL); err != nil {
+		return err
+	}
+	g.logInvalidateErrors(key, g.invalidatePeers(ctx, key, nil))
+	return nil
+}
+
+// InvalidateResult 记录了一次广播失效中各个远端节点的响应情况, key 为节点地址
+type InvalidateResult struct {
+	Acked  []string         // 确认失效的节点
+	Failed map[string]error // 失效失败的节点
+}
+
+// Delete 使 key 在整个集群中失效: 先由所有者处理, 再通知其它可能持有副本的节点
+// 开启了 write-through 或 write-behind 时, 所有者节点还会从数据源删除该值
+// 所有者处理失败时返回错误且不再通知其它节点, 其它节点的响应情况记录在 InvalidateResult 中
+func (g *Group) Delete(ctx context.Context, key string) (InvalidateResult, error) {
+	if key == "" {
+		return InvalidateResult{}, fmt.Errorf("key is required")
+	}
+
+	if g.picker != nil {
+		if owner, ok := g.picker.Pick(key); ok {
+			p, ok := owner.(Invalidator)
+			if !ok {
+				return InvalidateResult{}, fmt.Errorf("peer of key %s does not support Invalidate", key)
+			}
+			addr := ownerAddr(g.picker, owner)
+			_, err := p.Invalidate(ctx, &pb.InvalidateRequest{Group: g.name, Key: key, Owner: true})
+			if err != nil {
+				return InvalidateResult{Failed: map[string]error{addr: err}},
+					fmt.Errorf("failed to invalidate [%s] on owner: %w", key, err)
+			}
+			g.deleteCache(key)
+			result := g.invalidatePeers(ctx, key, owner)
+			result.Acked = append(result.Acked, addr)
+			return result, nil
+		}
+	}
+
+	if err := g.deleteOnOwner(ctx, key); err != nil {
+		return InvalidateResult{}, err
+	}
+	if g.picker == nil {
+		return InvalidateResult{}, nil
+	}
+	return g.invalidatePeers(ctx, key, nil), nil
+}
+
+// invalidatePeers 并发地通知远端节点删除 key, skip 不为空时跳过该节点, extra 为必须通知的节点
+// Picker 没有实现 PeerLister 时只会通知 extra 中的节点
+func (g *Group) invalidatePeers(ctx context.Context, key string, skip Fetcher, extra ...Fetcher) InvalidateResult {
+	targets := make(map[string]Fetcher)
+	if lister, ok := g.picker.(PeerLister); ok {
+		for addr, peer := range lister.Peers() {
+			if skip == nil || peer != skip {
+				targets[addr] = peer
+			}
+		}
+	}
+	for _, peer := range extra {
+		targets[ownerAddr(g.picker, peer)] = peer
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result = InvalidateResult{Failed: make(map[string]error)}
+	)
+	for addr, peer := range targets {
+		wg.Add(1)
+		go func(addr string, peer Fetcher) {
+			defer wg.Done()
+			err := fmt.Errorf("peer does not support Invalidate")
+			if p, ok := peer.(Invalidator); ok {
+				_, err = p.Invalidate(ctx, &pb.InvalidateRequest{Group: g.name, Key: key})
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed[addr] = err
+				return
+			}
+			result.Acked = append(result.Acked, addr)
+		}(addr, peer)
+	}
+	wg.Wait()
+
+	return result
+}
+
+// ownerAddr 在 PeerLister 中查找 peer 的地址, 找不到时返回 "owner"
+func ownerAddr(picker Picker, peer Fetcher) string {
+	if lister, ok := picker.(PeerLister); ok {
+		for addr, p := range lister.Peers() {
+			if p == peer {
+				return addr
+			}
+		}
+	}
+	return "owner"
+}
+
+func (g *Group) logInvalidateErrors(key string, result InvalidateResult) {
+	for addr, err := range result.Failed {
+		log.Printf("[dCache] Failed to invalidate [%s] on peer %s, %s\n", key, addr, err.Error())
+	}
+}
+
+// Update 使用默认的 TTL 将 value 写入 key 的所有者节点, 并使其它节点上的副本失效
 func (g *Group) Update(key, value string) {
 	if key == "" {
 		log.Println("[dCache] key is required")
 		return
 	}
 
-	// Delete the corresponding entry from the cache
-	g.deleteCache(key)
+	if err := g.Set(context.Background(), key, []byte(value), SetOptions{}); err != nil {
+		log.Printf("[dCache] Failed to update [%s], %s\n", key, err.Error())
+	}
+}
+
+// setLocally 将值写入本节点的 mainCache
+func (g *Group) setLocally(key string, value []byte, ttl time.Duration) {
+	g.negCache.delete(key)
+	view := ByteView{b: cloneBytes(value)}
+	if g.populatePinned(key, view) {
+		return
+	}
+	g.mainCache.addWithTTL(key, view, ttl)
+}
+
+// Name 返回 Group 的名称
+func (g *Group) Name() string {
+	return g.name
 }
 
 func (g *Group) deleteCache(key string) {
+	g.pins.drop(key)
 	g.mainCache.delete(key)
+	g.hotCache.delete(key)
+	g.negCache.delete(key)
 }
 
 func (g *Group) RegisterPeers(peers Picker) {
@@ -129,41 +654,162 @@ func (g *Group) RegisterPeers(peers Picker) {
 	g.picker = peers
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	// 每个 key 只加载一次，无论是缓存还是数据库, 无论是否并发
-	view, err := g.loader.Do(key, func() (interface{}, error) {
+	executed := false
+	view, err := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+		executed = true
 		if g.picker != nil {
 			if peer, ok := g.picker.Pick(key); ok {
-				bytes, err := peer.Fetch(g.name, key)
+				value, err := g.getFromPeer(ctx, peer, key)
 				if err == nil {
-					return ByteView{b: bytes}, nil
+					g.stats.peerLoads.Add(1)
+					g.populateHotCache(key, value)
+					return value, nil
 				}
+				// 所有者确认 key 不存在, 回退到本地加载也不会得到结果
+				if errors.Is(err, ErrNotFound) {
+					g.stats.peerLoads.Add(1)
+					g.populateNegativeCache(key)
+					return ByteView{}, err
+				}
+				g.stats.peerErrors.Add(1)
 				log.Printf("[dCache] Failed to get [%s] from peer, %s\n", key, err.Error())
+				// 调用方已经离开, 不再回退到本地加载
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ByteView{}, ctxErr
+				}
 			}
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
+	if !executed {
+		g.stats.loadsDeduped.Add(1)
+	}
 
-	if err != nil {
-		return view.(ByteView), err
+	if err == nil {
+		return view.(ByteView), nil
 	}
 
 	return
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+// refreshInBackground 在后台从数据源重新加载 key, 同一个 key 同时只有一个后台刷新
+// 加载通过 singleFlight 进行, 与同时发生的前台加载合并
+func (g *Group) refreshInBackground(key string) {
+	if _, loaded := g.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer g.refreshing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundRefreshTimeout)
+		defer cancel()
+
+		_, err := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+			return g.getLocally(ctx, key)
+		})
+		if err != nil {
+			log.Printf("[dCache] Failed to refresh [%s], %s\n", key, err.Error())
+		}
+	}()
+}
+
+// getFromPeer 优先使用 FetcherWithContext, 以便 ctx 随 gRPC 请求传递到远端节点
+func (g *Group) getFromPeer(ctx context.Context, peer Fetcher, key string) (ByteView, error) {
+	if p, ok := peer.(FetcherWithContext); ok {
+		resp, err := p.FetchContext(ctx, &pb.DCacheRequest{Group: g.name, Key: key})
+		if err != nil {
+			return ByteView{}, err
+		}
+		return ByteView{
+			b:        resp.GetValue(),
+			stale:    resp.GetStale(),
+			expireAt: expireAtFromTTL(time.Duration(resp.GetTtlNs())),
+		}, nil
+	}
+
+	bytes, err := peer.Fetch(g.name, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return ByteView{b: bytes}, nil
+}
+
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	// 尚未写回数据源的修改比数据源中的值更新
+	if g.writer != nil {
+		if wr, ok := g.writer.pending(key); ok {
+			if wr.Delete {
+				return ByteView{}, fmt.Errorf("key %s: %w", key, ErrNotFound)
+			}
+			value := ByteView{b: wr.Value}
+			value.expireAt = g.populateCache(key, value, time.Time{}, 0)
+			return value, nil
+		}
+	}
+
+	start := time.Now()
+	entry, err := g.getter.GetEntry(ctx, key)
 	if err != nil {
+		g.stats.localLoadErrs.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegativeCache(key)
+		}
 		return ByteView{}, err
 	}
+	g.stats.localLoads.Add(1)
 
-	value := ByteView{b: cloneBytes(bytes)}
+	if entry.Cost <= 0 {
+		entry.Cost = time.Since(start)
+	}
+	value := ByteView{b: cloneBytes(entry.Value), delta: entry.Cost}
+	if entry.Pin {
+		ok, err := g.pinValue(key, value)
+		if ok {
+			return value, nil
+		}
+		// 没有设置 WithPinnedBytes 时不能固定任何值, 不必每次加载都打印日志
+		if g.pins.maxBytes > 0 {
+			log.Printf("[dCache] %s, caching it unpinned\n", err.Error())
+		}
+	}
 	// 将数据添加到缓存中
-	g.populateCache(key, value)
+	value.expireAt = g.populateCache(key, value, entry.ExpireAt, entry.Cost)
 
 	return value, nil
 }
 
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// populateCache 将值添加到 mainCache 并返回其过期时间, expireAt 为零值时使用默认的 TTL
+// cost 为重新加载该值的代价, 为 0 时使用 value 中记录的加载耗时; 已经过期的值不会被缓存
+func (g *Group) populateCache(key string, value ByteView, expireAt time.Time, cost time.Duration) time.Time {
+	g.negCache.delete(key)
+	if g.populatePinned(key, value) {
+		return time.Time{}
+	}
+	if expireAt.IsZero() {
+		return g.mainCache.add(key, value, cost)
+	}
+	if expireAt.After(time.Now()) {
+		g.mainCache.addWithExpire(key, value, expireAt, cost)
+	}
+	return expireAt
+}
+
+// populateHotCache 随机保存从远端节点获取的值, 过期时间不会晚于所有者设置的过期时间
+func (g *Group) populateHotCache(key string, value ByteView) {
+	// 所有者返回的旧值不放入 hotCache
+	if value.stale || g.populatePinned(key, value) || g.hotCache.cacheBytes <= 0 || !hotCacheSample() {
+		return
+	}
+	if !value.expireAt.IsZero() && !value.expireAt.After(time.Now()) {
+		return
+	}
+	g.hotCache.addBefore(key, value, 0, value.expireAt)
+}
+
+func (g *Group) populateNegativeCache(key string) {
+	if g.negCache.cacheBytes > 0 {
+		g.negCache.add(key, ByteView{}, 0)
+	}
 }
@@ -6,9 +6,17 @@
 package dCache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
+	"github.com/Daz-3ux/dazCache/dCache/lru"
 	"log"
+	"reflect"
+	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var db = map[string]string{
@@ -58,3 +66,729 @@ func TestGetterFunc_Get(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGroup_GetContext(t *testing.T) {
+	getter := GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, fmt.Errorf("deadline of %s is lost", key)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			return []byte(key), nil
+		}
+	})
+	gee := NewGroup("dCacheTestContext", 2<<10, getter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if view, err := gee.GetContext(ctx, "daz"); err != nil || view.String() != "daz" {
+		t.Fatalf("failed to get value of daz, err: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect context.DeadlineExceeded, but %v got", err)
+	}
+}
+
+func TestGroup_GetContextSharedLoad(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	getter := GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte(key), nil
+	})
+	g, _ := NewRegistry().NewGroup("sharedLoad", 2<<10, getter)
+
+	leaderCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(leaderCtx, "daz")
+		leaderErr <- err
+	}()
+	<-started
+
+	// 等待者与第一个调用者共享同一次加载, 第一个调用者超时不应使等待者失败
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if view, err := g.GetContext(ctx, "daz"); err != nil || view.String() != "daz" {
+		t.Fatalf("waiter with a longer deadline should get the value, but %v got", err)
+	}
+	if err := <-leaderErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("leader should fail with its own deadline, but %v got", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("waiter should retry the load once, but %d loads got", n)
+	}
+}
+
+// testPeer 在进程内模拟一个远端节点, 直接调用对应 Group 的方法
+type testPeer struct {
+	g       *Group
+	fetches int
+	batches int
+}
+
+func (p *testPeer) FetchContext(ctx context.Context, in *pb.DCacheRequest) (*pb.DCacheResponse, error) {
+	p.fetches++
+	view, err := p.g.GetContext(ctx, in.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	return newGetResponse(view), nil
+}
+
+func (p *testPeer) BatchFetch(ctx context.Context, in *pb.BatchGetRequest) (*pb.BatchGetResponse, error) {
+	p.batches++
+	return batchResponse(p.g.GetMany(ctx, in.GetKeys())), nil
+}
+
+func (p *testPeer) Fetch(group string, key string) ([]byte, error) {
+	p.fetches++
+	view, err := p.g.Get(key)
+	return view.ByteSlice(), err
+}
+
+func (p *testPeer) Put(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	if err := p.g.setOnOwner(ctx, in.GetKey(), in.GetValue(), time.Duration(in.GetTtlNs())); err != nil {
+		return nil, err
+	}
+	return &pb.SetResponse{}, nil
+}
+
+func (p *testPeer) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	if in.GetOwner() {
+		if err := p.g.deleteOnOwner(ctx, in.GetKey()); err != nil {
+			return nil, err
+		}
+		return &pb.InvalidateResponse{}, nil
+	}
+	p.g.deleteCache(in.GetKey())
+	return &pb.InvalidateResponse{}, nil
+}
+
+// testPicker 将 owners 中的 key 路由到对应的 testPeer, 其余 key 由本节点处理
+// peers 为全部远端节点, 用于广播失效
+type testPicker struct {
+	owners map[string]*testPeer
+	peers  map[string]*testPeer
+}
+
+func (p *testPicker) Peers() map[string]Fetcher {
+	peers := make(map[string]Fetcher, len(p.peers))
+	for addr, peer := range p.peers {
+		peers[addr] = peer
+	}
+	return peers
+}
+
+func (p *testPicker) Pick(key string) (Fetcher, bool) {
+	peer, ok := p.owners[key]
+	if !ok {
+		return nil, false
+	}
+	return peer, true
+}
+
+func TestGroup_Set(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("origin"), nil
+	})
+	remote := NewGroup("dCacheTestSetRemote", 2<<10, getter)
+	local := NewGroup("dCacheTestSetLocal", 2<<10, getter)
+	local.RegisterPeers(&testPicker{owners: map[string]*testPeer{"remote": {g: remote}}})
+
+	ctx := context.Background()
+	for _, key := range []string{"local", "remote"} {
+		if view, err := local.Get(key); err != nil || view.String() != "origin" {
+			t.Fatalf("failed to get value of %s", key)
+		}
+		if err := local.Set(ctx, key, []byte("new"), SetOptions{}); err != nil {
+			t.Fatalf("failed to set %s: %v", key, err)
+		}
+		if view, err := local.Get(key); err != nil || view.String() != "new" {
+			t.Fatalf("read after set of %s got %q, %v", key, view.String(), err)
+		}
+	}
+	if view, ok := remote.mainCache.get("remote"); !ok || view.String() != "new" {
+		t.Fatalf("owner does not hold the new value of remote")
+	}
+
+	if err := local.Set(ctx, "ttl", []byte("short"), SetOptions{TTL: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("failed to set ttl: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if view, err := local.Get("ttl"); err != nil || view.String() != "origin" {
+		t.Fatalf("value of ttl should expire, but %q got", view.String())
+	}
+}
+
+func TestGroup_Delete(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("origin"), nil
+	})
+	owner := &testPeer{g: NewGroup("dCacheTestDeleteOwner", 2<<10, getter)}
+	other := &testPeer{g: NewGroup("dCacheTestDeleteOther", 2<<10, getter)}
+	local := NewGroup("dCacheTestDeleteLocal", 2<<10, getter)
+	local.RegisterPeers(&testPicker{
+		owners: map[string]*testPeer{"key": owner},
+		peers:  map[string]*testPeer{"owner:1": owner, "other:2": other},
+	})
+
+	for _, g := range []*Group{owner.g, other.g, local} {
+		g.populateCache("key", ByteView{b: []byte("stale")}, time.Time{}, 0)
+	}
+
+	result, err := local.Delete(context.Background(), "key")
+	if err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	if len(result.Acked) != 2 || len(result.Failed) != 0 {
+		t.Fatalf("expect 2 peers acked, but %v got", result)
+	}
+	for _, g := range []*Group{owner.g, other.g, local} {
+		if _, ok := g.mainCache.get("key"); ok {
+			t.Fatalf("key is still cached in %s", g.name)
+		}
+	}
+}
+
+func TestGroup_HotCache(t *testing.T) {
+	sample := hotCacheSample
+	defer func() { hotCacheSample = sample }()
+	hotCacheSample = func() bool { return true }
+
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("origin"), nil
+	})
+	owner := &testPeer{g: NewGroup("dCacheTestHotOwner", 2<<10, getter)}
+	local := NewGroup("dCacheTestHotLocal", 2<<10, getter, WithHotCache(1<<10, 10*time.Millisecond))
+	local.RegisterPeers(&testPicker{owners: map[string]*testPeer{"hot": owner}})
+
+	for i := 0; i < 3; i++ {
+		if view, err := local.Get("hot"); err != nil || view.String() != "origin" {
+			t.Fatalf("failed to get value of hot")
+		}
+	}
+	if owner.fetches != 1 {
+		t.Fatalf("expect 1 fetch from owner, but %d got", owner.fetches)
+	}
+	if _, ok := local.mainCache.get("hot"); ok {
+		t.Fatalf("value fetched from peer should not be stored in mainCache")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := local.Get("hot"); err != nil || owner.fetches != 2 {
+		t.Fatalf("hot cache entry should expire, fetches: %d", owner.fetches)
+	}
+}
+
+func TestGroup_HotCacheDefaultTTL(t *testing.T) {
+	sample := hotCacheSample
+	defer func() { hotCacheSample = sample }()
+	hotCacheSample = func() bool { return true }
+
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("origin"), nil
+	})
+	// 所有者的值永不过期, hotCache 中的副本使用 mainCache 的 TTL
+	owner := &testPeer{g: NewGroup("dCacheTestHotTTLOwner", 2<<10, getter)}
+	local := NewGroup("dCacheTestHotTTLLocal", 2<<10, getter, WithTTL(10*time.Millisecond))
+	local.RegisterPeers(&testPicker{owners: map[string]*testPeer{"hot": owner}})
+	if local.hotCache.ttl != 10*time.Millisecond {
+		t.Fatalf("hotCache TTL should default to the mainCache TTL, but %s got", local.hotCache.ttl)
+	}
+
+	_, _ = local.Get("hot")
+	_, _ = local.Get("hot")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := local.Get("hot"); err != nil || owner.fetches != 2 {
+		t.Fatalf("hot cache entry should expire with the mainCache TTL, fetches: %d", owner.fetches)
+	}
+}
+
+func TestGroup_NegativeCache(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+	})
+	gee := NewGroup("dCacheTestNegative", 2<<10, getter, WithNegativeCache(1<<10, 10*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but %v got", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expect 1 load of unknown, but %d got", loads)
+	}
+	if _, items, _ := gee.mainCache.stats(); items != 0 {
+		t.Fatalf("negative results should not be stored in mainCache")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 2 {
+		t.Fatalf("negative cache entry should expire, loads: %d", loads)
+	}
+
+	if err := gee.Set(context.Background(), "unknown", []byte("known"), SetOptions{}); err != nil {
+		t.Fatalf("failed to set unknown: %v", err)
+	}
+	if view, err := gee.Get("unknown"); err != nil || view.String() != "known" {
+		t.Fatalf("set should clear the negative cache entry, but %v got", err)
+	}
+}
+
+func TestGroup_Stats(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+	gee := NewGroup("dCacheTestStats", 2<<10, getter)
+
+	_, _ = gee.Get("daz")
+	_, _ = gee.Get("daz")
+	_, _ = gee.Get("unknown")
+
+	stats := gee.Stats()
+	if stats.Gets != 3 || stats.CacheHits != 1 || stats.LocalLoads != 1 || stats.LocalLoadErrs != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.MainCacheItems != 1 || stats.MainCacheBytes != int64(len("daz")+len("666")) {
+		t.Fatalf("unexpected mainCache stats %+v", stats)
+	}
+	gee.deleteCache("daz")
+	if stats := gee.Stats(); stats.MainCacheItems != 0 || stats.Evictions != 0 {
+		t.Fatalf("deleted entries should not be counted as evictions, %+v", stats)
+	}
+
+	svr, err := NewServer("localhost:9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = svr.Get(context.Background(), &pb.DCacheRequest{Group: "dCacheTestStats", Key: "daz"})
+	_, _ = svr.Get(context.Background(), &pb.DCacheRequest{Group: "dCacheTestStats", Key: ""})
+	if s := svr.Stats(); s.Gets != 2 || s.GetErrors != 1 {
+		t.Fatalf("unexpected server stats %+v", s)
+	}
+}
+
+func TestGroup_GetMany(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+	})
+	owner := &testPeer{g: NewGroup("dCacheTestBatchOwner", 2<<10, getter)}
+	local := NewGroup("dCacheTestBatchLocal", 2<<10, getter)
+	local.RegisterPeers(&testPicker{owners: map[string]*testPeer{
+		"fakedaz": owner, "realdaz": owner, "unknown": owner,
+	}})
+
+	keys := []string{"daz", "fakedaz", "realdaz", "unknown", "daz", ""}
+	results := local.GetMany(context.Background(), keys)
+	if len(results) != len(keys) {
+		t.Fatalf("expect %d results, but %d got", len(keys), len(results))
+	}
+	for i, key := range keys[:3] {
+		if results[i].Key != key || results[i].Err != nil || results[i].Value.String() != db[key] {
+			t.Fatalf("unexpected result of %s: %+v", key, results[i])
+		}
+	}
+	if !errors.Is(results[3].Err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound of unknown, but %v got", results[3].Err)
+	}
+	if results[4].Value.String() != db["daz"] || results[5].Err == nil {
+		t.Fatalf("unexpected results of duplicated or empty keys: %+v", results[4:])
+	}
+	if owner.batches != 1 || owner.fetches != 0 {
+		t.Fatalf("expect 1 batch and no single fetch, but %d and %d got", owner.batches, owner.fetches)
+	}
+	if _, ok := local.mainCache.get("daz"); !ok {
+		t.Fatalf("local key daz should be cached")
+	}
+}
+
+func TestGroup_StaleWhileRevalidate(t *testing.T) {
+	loaded := make(chan struct{}, 1)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		defer func() { loaded <- struct{}{} }()
+		return []byte("fresh"), nil
+	})
+	gee := NewGroup("dCacheTestSWR", 2<<10, getter, WithStaleWhileRevalidate(time.Second))
+
+	if err := gee.Set(context.Background(), "daz", []byte("old"), SetOptions{TTL: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("failed to set daz: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if view, err := gee.Get("daz"); err != nil || view.String() != "old" {
+		t.Fatalf("expect stale value old, but %q got", view.String())
+	}
+	if stats := gee.Stats(); stats.StaleHits != 1 {
+		t.Fatalf("expect 1 stale hit, but %d got", stats.StaleHits)
+	}
+
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Fatalf("background refresh is not triggered")
+	}
+	// 等待后台刷新写入缓存
+	for i := 0; i < 100; i++ {
+		if view, _ := gee.Get("daz"); view.String() == "fresh" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("value of daz is not refreshed")
+}
+
+func TestGroup_StaleIfError(t *testing.T) {
+	var originErr error
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, originErr
+	})
+	gee := NewGroup("dCacheTestSIE", 2<<10, getter, WithStaleIfError(50*time.Millisecond))
+
+	if err := gee.Set(context.Background(), "daz", []byte("old"), SetOptions{TTL: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("failed to set daz: %v", err)
+	}
+	if view, err := gee.Get("daz"); err != nil || view.Stale() {
+		t.Fatalf("fresh value should not be stale")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	originErr = fmt.Errorf("database is down")
+	view, err := gee.Get("daz")
+	if err != nil || view.String() != "old" || !view.Stale() {
+		t.Fatalf("expect stale value old, but %q, %v got", view.String(), err)
+	}
+
+	originErr = fmt.Errorf("daz not exist: %w", ErrNotFound)
+	if _, err := gee.Get("daz"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ErrNotFound should not be hidden by stale value, but %v got", err)
+	}
+
+	originErr = fmt.Errorf("database is down")
+	time.Sleep(50 * time.Millisecond)
+	if _, err := gee.Get("daz"); err == nil {
+		t.Fatalf("value beyond stale-if-error window should not be served")
+	}
+}
+
+func TestGroup_EntryGetter(t *testing.T) {
+	sample := hotCacheSample
+	defer func() { hotCacheSample = sample }()
+	hotCacheSample = func() bool { return true }
+
+	getter := EntryGetterFunc(func(ctx context.Context, key string) (Entry, error) {
+		return Entry{Value: []byte(key), ExpireAt: time.Now().Add(30 * time.Millisecond)}, nil
+	})
+	owner := &testPeer{g: NewGroup("dCacheTestEntryOwner", 2<<10, getter)}
+	local := NewGroup("dCacheTestEntryLocal", 2<<10, getter, WithHotCache(1<<10, time.Hour))
+	local.RegisterPeers(&testPicker{owners: map[string]*testPeer{"remote": owner}})
+
+	view, err := local.Get("remote")
+	if err != nil || view.String() != "remote" {
+		t.Fatalf("failed to get value of remote")
+	}
+	if ttl := time.Until(view.ExpireAt()); ttl <= 0 || ttl > 30*time.Millisecond {
+		t.Fatalf("remaining TTL should travel from the owner, but %v got", ttl)
+	}
+	if _, err := local.Get("remote"); err != nil || owner.fetches != 1 {
+		t.Fatalf("value of remote should be served from hot cache")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, err := local.Get("remote"); err != nil || owner.fetches != 2 {
+		t.Fatalf("hot cache should not extend the TTL of the owner, fetches: %d", owner.fetches)
+	}
+	if view, err := local.Get("local"); err != nil || time.Until(view.ExpireAt()) > 30*time.Millisecond {
+		t.Fatalf("expiration from EntryGetter should be used by mainCache")
+	}
+}
+
+func TestGroup_EvictionPolicy(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	// 每条记录占用 2 字节, mainCache 最多保存 3 条
+	g, _ := NewRegistry().NewGroup("evictionPolicy", 6, getter, WithEvictionPolicy(func() lru.EvictionPolicy {
+		return lru.NewLFU()
+	}))
+	for i := 0; i < 3; i++ {
+		_, _ = g.Get("a")
+	}
+	_, _ = g.Get("b")
+	_, _ = g.Get("b")
+	_, _ = g.Get("c")
+	_, _ = g.Get("d")
+	for key, cached := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		if _, ok := g.mainCache.get(key); ok != cached {
+			t.Fatalf("LFU should evict the least frequently used key, %s cached: %v", key, ok)
+		}
+	}
+}
+
+func TestGroup_Admission(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	// 每条记录占用 2 字节, mainCache 最多保存 3 条
+	g, _ := NewRegistry().NewGroup("admission", 6, getter, WithAdmission(func() lru.AdmissionPolicy {
+		return lru.NewTinyLFU(64)
+	}))
+	for _, key := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			_, _ = g.Get(key)
+		}
+	}
+	// 新 key 先进入准入窗口, 被下一个新 key 挤出窗口时与淘汰对象比较
+	for _, key := range []string{"x", "z"} {
+		if view, err := g.Get(key); err != nil || view.String() != key {
+			t.Fatalf("rejected value should still be returned")
+		}
+	}
+	for key, cached := range map[string]bool{"x": false, "b": true, "c": true, "z": true} {
+		if _, ok := g.mainCache.get(key); ok != cached {
+			t.Fatalf("one-hit key should not replace frequently used keys, %s cached: %v", key, ok)
+		}
+	}
+	if err := g.Set(context.Background(), "y", []byte("y"), SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mainCache.get("y"); !ok {
+		t.Fatalf("value written by Set should bypass admission")
+	}
+}
+
+func TestGroup_CostAwareEviction(t *testing.T) {
+	getter := EntryGetterFunc(func(ctx context.Context, key string) (Entry, error) {
+		if key == "s" {
+			return Entry{Value: []byte(key), Cost: time.Second}, nil
+		}
+		return Entry{Value: []byte(key), Cost: 2 * time.Millisecond}, nil
+	})
+	// 每条记录占用 2 字节, mainCache 最多保存 3 条
+	g, _ := NewRegistry().NewGroup("costAware", 6, getter, WithEvictionPolicy(func() lru.EvictionPolicy {
+		return lru.NewGreedyDualSize()
+	}))
+	for _, key := range []string{"s", "a", "b", "c"} {
+		_, _ = g.Get(key)
+	}
+	for key, cached := range map[string]bool{"s": true, "a": false, "b": true, "c": true} {
+		if _, ok := g.mainCache.get(key); ok != cached {
+			t.Fatalf("expensive key should outlive cheap ones, %s cached: %v", key, ok)
+		}
+	}
+
+	// Set 写入的值代价未知, 使用平均代价, 不会比昂贵的值更晚被淘汰
+	for _, key := range []string{"x", "y", "z"} {
+		_ = g.Set(context.Background(), key, []byte(key), SetOptions{})
+	}
+	for key, cached := range map[string]bool{"s": true, "x": false, "y": true, "z": true} {
+		if _, ok := g.mainCache.get(key); ok != cached {
+			t.Fatalf("values with unknown cost should not outrank expensive ones, %s cached: %v", key, ok)
+		}
+	}
+}
+
+func TestGroup_Shards(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	g, _ := NewRegistry().NewGroup("shards", 4<<10, getter, WithShards(4))
+	for i := 0; i < 1000; i++ {
+		_, _ = g.Get(fmt.Sprintf("key%d", i))
+	}
+
+	shards := g.mainCache.shardList()
+	if len(shards) != 4 || len(g.hotCache.shardList()) != 4 {
+		t.Fatalf("expect 4 shards, but %d got", len(shards))
+	}
+	for _, s := range shards {
+		bytes, items, _ := s.stats()
+		if s.maxBytes != 1<<10 || bytes > s.maxBytes || items == 0 {
+			t.Fatalf("each shard should hold its share of cacheBytes, %d/%d bytes, %d items", bytes, s.maxBytes, items)
+		}
+	}
+	if view, err := g.Get("key999"); err != nil || view.String() != "key999" {
+		t.Fatalf("failed to get key999 from shards")
+	}
+
+	if n := defaultShards(2 << 10); n != 1 {
+		t.Fatalf("small cache should not be sharded by default, but %d shards got", n)
+	}
+}
+
+func TestGroup_ShardsLargeValue(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if key != "big" {
+			return []byte(key), nil
+		}
+		loads++
+		return make([]byte, 2<<20), nil
+	})
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(16))
+	g, _ := NewRegistry().NewGroup("largeValue", 64<<20, getter)
+	for i := 0; i < 3; i++ {
+		_, _ = g.Get("big")
+	}
+	if stats := g.Stats(); loads != 1 || stats.MainCacheItems != 1 || stats.Evictions != 0 {
+		t.Fatalf("default shards should hold a 2MB value, %d loads, %+v", loads, stats)
+	}
+
+	// 超过分区容量的值不会被缓存, 也不会淘汰其它记录
+	g, _ = NewRegistry().NewGroup("oversize", 64<<20, getter, WithShards(64))
+	_, _ = g.Get("small")
+	for i := 0; i < 3; i++ {
+		if view, err := g.Get("big"); err != nil || view.Len() != 2<<20 {
+			t.Fatalf("oversized value should still be returned")
+		}
+	}
+	if stats := g.Stats(); stats.MainCacheItems != 1 || stats.Evictions != 0 {
+		t.Fatalf("oversized value should be skipped without evictions, %+v", stats)
+	}
+}
+
+func TestGroup_Options(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	var evicted []string
+	g, err := NewRegistry().NewGroup("options", 0, getter, WithShards(1),
+		WithConfig(lru.Config{K: 2, TTL: "1h", MaxEntries: 2}),
+		WithOnEvicted(func(key string, value ByteView) {
+			evicted = append(evicted, key+"="+value.String())
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		_, _ = g.Get(key)
+	}
+	if _, items, _ := g.mainCache.stats(); items != 2 || !reflect.DeepEqual(evicted, []string{"a=a"}) {
+		t.Fatalf("MaxEntries should evict the oldest key, %d items, evicted %v", items, evicted)
+	}
+	if view, _ := g.Get("c"); time.Until(view.ExpireAt()) <= 59*time.Minute {
+		t.Fatalf("TTL in config should be used, but expires at %s", view.ExpireAt())
+	}
+
+	for name, opt := range map[string]GroupOption{
+		"ttl":        WithConfig(lru.Config{TTL: "ten seconds"}),
+		"k":          WithK(-1),
+		"maxEntries": WithMaxEntries(-1),
+		"negTTL":     WithTTL(-time.Second),
+		"shards":     WithShards(-1),
+		"hotCache":   WithHotCache(-1, 0),
+		"negCache":   WithNegativeCache(1<<10, 0),
+	} {
+		r := NewRegistry()
+		if _, err := r.NewGroup(name, 0, getter, opt); err == nil || r.GetGroup(name) != nil {
+			t.Fatalf("invalid option %s should return an error", name)
+		}
+	}
+}
+
+func TestGroup_IdleTTL(t *testing.T) {
+	var loads atomic.Int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	})
+	g, _ := NewRegistry().NewGroup("idle", 2<<10, getter,
+		WithIdleTTL(100*time.Millisecond), WithTTL(300*time.Millisecond))
+
+	// 频繁读取的值不会因空闲而过期, 但不会超过 WithTTL 设置的最长生存时间
+	start := time.Now()
+	for time.Since(start) < 200*time.Millisecond {
+		_, _ = g.Get("session")
+		time.Sleep(40 * time.Millisecond)
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("frequently read key should not be reloaded, but loaded %d times", n)
+	}
+	for time.Since(start) < 400*time.Millisecond {
+		_, _ = g.Get("session")
+		time.Sleep(40 * time.Millisecond)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("key should be reloaded after the max lifetime, but loaded %d times", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if view, _ := g.Get("session"); loads.Load() != 3 || time.Until(view.ExpireAt()) > 100*time.Millisecond {
+		t.Fatalf("idle key should be reloaded and expire after the idle timeout")
+	}
+}
+
+func TestGroup_TTLJitter(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	g, _ := NewRegistry().NewGroup("jitter", 2<<10, getter, WithTTL(time.Hour), WithTTLJitter(0.5))
+	seen := make(map[time.Time]bool)
+	for i := 0; i < 20; i++ {
+		view, _ := g.Get(fmt.Sprintf("key%d", i))
+		if ttl := time.Until(view.ExpireAt()); ttl > time.Hour || ttl < 29*time.Minute {
+			t.Fatalf("jittered TTL should be within [0.5, 1] * TTL, but %s got", ttl)
+		}
+		seen[view.ExpireAt().Truncate(time.Minute)] = true
+	}
+	if len(seen) < 5 {
+		t.Fatalf("keys loaded together should not expire together")
+	}
+	if _, err := NewRegistry().NewGroup("jitter", 0, getter, WithTTLJitter(1.5)); err == nil {
+		t.Fatalf("jitter out of range should return an error")
+	}
+}
+
+func TestGroup_EarlyRefresh(t *testing.T) {
+	var loads atomic.Int32
+	getter := EntryGetterFunc(func(ctx context.Context, key string) (Entry, error) {
+		n := loads.Add(1)
+		return Entry{Value: []byte(fmt.Sprint(n)), ExpireAt: time.Now().Add(time.Minute), Cost: time.Minute}, nil
+	})
+	g, _ := NewRegistry().NewGroup("early", 2<<10, getter, WithEarlyRefresh(1))
+	defer func(f func() float64) { earlyRefreshRand = f }(earlyRefreshRand)
+
+	// 距离过期还有 1 分钟, 加载耗时 1 分钟: -log(1-r) < 1 时不刷新
+	earlyRefreshRand = func() float64 { return 0.5 }
+	_, _ = g.Get("key")
+	if view, _ := g.Get("key"); view.String() != "1" || g.Stats().EarlyRefreshes != 0 {
+		t.Fatalf("key far from expiry should not be refreshed")
+	}
+
+	// -log(1-r) >= 1 时提前刷新, 调用方立即得到当前的值
+	earlyRefreshRand = func() float64 { return 0.99 }
+	if view, _ := g.Get("key"); view.String() != "1" || view.Stale() {
+		t.Fatalf("early refresh should not block the caller, but %s got", view.String())
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if view, _ := g.mainCache.get("key"); view.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key should be refreshed in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := g.Stats().EarlyRefreshes; n != 1 {
+		t.Fatalf("expect 1 early refresh, but %d got", n)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultExpiryInterval 是默认的主动过期间隔
+	defaultExpiryInterval = time.Second
+	// expiryBatch 是每个分区每次加锁最多删除的过期记录数, 以免长时间持有锁
+	expiryBatch = 128
+)
+
+// WithExpiryInterval 设置主动过期的间隔, 默认为 1s; interval <= 0 时关闭主动过期
+// 后台任务每隔 interval 删除 mainCache, hotCache 与负缓存中超出保留期的过期记录并触发 WithOnEvicted 的回调,
+// 因此过期记录最多在 interval 之后被释放; 关闭后过期记录只在被访问或被淘汰时删除
+func WithExpiryInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.expiryInterval = interval
+	}
+}
+
+// removeExpired 删除全部分区中在 now 时超出保留期的过期记录, 返回删除的条数
+func (c *cache) removeExpired(now time.Time) int {
+	n := 0
+	for _, s := range c.shardList() {
+		n += s.removeExpired(now)
+	}
+	return n
+}
+
+func (c *cacheShard) removeExpired(now time.Time) int {
+	n := 0
+	for {
+		c.mu.Lock()
+		removed := 0
+		if c.lru != nil {
+			removed = c.lru.RemoveExpired(now, expiryBatch)
+		}
+		c.mu.Unlock()
+
+		n += removed
+		if removed < expiryBatch {
+			return n
+		}
+	}
+}
+
+// janitor 是定期删除过期记录的后台任务, 随 Group 创建, 在 RemoveGroup 时停止
+type janitor struct {
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startJanitor(interval time.Duration, caches ...*cache) *janitor {
+	j := &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go j.run(interval, caches)
+
+	return j
+}
+
+func (j *janitor) run(interval time.Duration, caches []*cache) {
+	defer close(j.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case now := <-ticker.C:
+			for _, c := range caches {
+				c.removeExpired(now)
+			}
+		}
+	}
+}
+
+// close 停止后台任务并等待正在进行的清理结束, 可以重复调用
+func (j *janitor) close() {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroup_ActiveExpiry(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	var mu sync.Mutex
+	evicted := make(map[string]bool)
+	r := NewRegistry()
+	g, err := r.NewGroup("expiry", 2<<10, getter, WithShards(4),
+		WithTTL(20*time.Millisecond), WithExpiryInterval(10*time.Millisecond),
+		WithOnEvicted(func(key string, value ByteView) {
+			mu.Lock()
+			defer mu.Unlock()
+			evicted[key] = true
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		_, _ = g.Get(fmt.Sprintf("key%d", i))
+	}
+
+	// 没有任何访问, 过期记录也会在 TTL + interval 之后被删除
+	deadline := time.Now().Add(time.Second)
+	for {
+		bytes, items, _ := g.mainCache.stats()
+		if items == 0 && bytes == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired entries should be removed in background, %d items left", items)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	if len(evicted) != 10 {
+		t.Fatalf("OnEvicted should be called for every expired entry, but %d got", len(evicted))
+	}
+	mu.Unlock()
+
+	j := g.janitor
+	r.RemoveGroup("expiry")
+	select {
+	case <-j.done:
+	default:
+		t.Fatalf("janitor should stop after the group is removed")
+	}
+
+	g, _ = r.NewGroup("disabled", 2<<10, getter, WithExpiryInterval(0))
+	if g.janitor != nil {
+		t.Fatalf("janitor should not be started when active expiration is disabled")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config 是配置文件的格式, 见 LoadConfig
+type Config struct {
+	K          int     `json:"k" yaml:"k"`
+	TTL        string  `json:"TTL" yaml:"ttl"`             // 例如 "10s", 为空或 0 表示永不过期
+	IdleTTL    string  `json:"idleTTL" yaml:"idleTTL"`     // 空闲过期时间, 格式与 TTL 相同
+	TTLJitter  float64 `json:"ttlJitter" yaml:"ttlJitter"` // TTL 随机缩短的最大比例, 例如 0.1
+	MaxBytes   int64   `json:"maxBytes" yaml:"maxBytes"`
+	MaxEntries int     `json:"maxEntries" yaml:"maxEntries"`
+}
+
+// Options 将 Config 转换为 Options, TTL 格式错误或配置不合法时返回错误
+func (c Config) Options() (Options, error) {
+	ttl, err := parseDuration("TTL", c.TTL)
+	if err != nil {
+		return Options{}, err
+	}
+	idle, err := parseDuration("idleTTL", c.IdleTTL)
+	if err != nil {
+		return Options{}, err
+	}
+	opts := Options{
+		MaxBytes:   c.MaxBytes,
+		MaxEntries: c.MaxEntries,
+		K:          c.K,
+		TTL:        ttl,
+		IdleTTL:    idle,
+		TTLJitter:  c.TTLJitter,
+	}
+	if err := opts.Validate(); err != nil {
+		return Options{}, err
+	}
+	return opts, nil
+}
+
+// parseDuration 解析名为 name 的时长, 为空时返回 0
+func parseDuration(name, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("lru: invalid %s %q: %w", name, s, err)
+	}
+	return d, nil
+}
+
+// LoadConfig 读取 JSON 或 YAML 格式的配置文件, 格式由扩展名 .json, .yaml 或 .yml 决定
+// 文件中的未知字段与不合法的配置都会返回错误
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&config)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &config)
+	default:
+		return Config{}, fmt.Errorf("lru: unsupported config format %q", ext)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("lru: parse %s: %w", path, err)
+	}
+	if _, err := config.Options(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"container/heap"
+	"time"
+)
+
+/*
+   主动过期:
+   设置了过期时间的记录同时保存在按 expireAt 排序的最小堆中,
+   RemoveExpired 从堆顶开始删除超出保留期的记录, 不需要遍历全部记录
+   Get 仍会删除访问到的过期记录, 两者互不影响
+*/
+
+// expiryHeap 是按 expireAt 排序的最小堆, 只包含设置了过期时间的记录
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expireAt.Before(h[j].expireAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
+
+// setExpire 更新 kv 的过期时间并维护 expiry, kv 不在堆中时 index 为 -1
+func (c *Cache) setExpire(kv *entry, expireAt time.Time) {
+	kv.expireAt = expireAt
+	switch {
+	case expireAt.IsZero() && kv.index >= 0:
+		heap.Remove(&c.expiry, kv.index)
+	case expireAt.IsZero():
+	case kv.index >= 0:
+		heap.Fix(&c.expiry, kv.index)
+	default:
+		heap.Push(&c.expiry, kv)
+	}
+}
+
+// RemoveExpired 删除在 now 时已超出 Grace 保留期的过期记录, 被删除的记录同样会触发回调
+// limit 为一次最多删除的条数, <= 0 时不限制; 返回删除的条数
+func (c *Cache) RemoveExpired(now time.Time, limit int) int {
+	n := 0
+	for len(c.expiry) > 0 && (limit <= 0 || n < limit) {
+		kv := c.expiry[0]
+		if !kv.expireAt.Add(c.Grace).Before(now) {
+			break
+		}
+		c.remove(kv)
+		n++
+	}
+	return n
+}
+
+// NextExpiry 返回最早过期的记录的过期时间, 没有设置了过期时间的记录时 ok 为 false
+func (c *Cache) NextExpiry() (expireAt time.Time, ok bool) {
+	if len(c.expiry) == 0 {
+		return time.Time{}, false
+	}
+	return c.expiry[0].expireAt, true
+}
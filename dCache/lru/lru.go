@@ -7,39 +7,58 @@ package lru
 
 import (
 	"container/list"
-	"encoding/json"
-	"os"
+	"fmt"
+	"math/rand"
 	"time"
 )
 
 /*
    LRU 缓存淘汰算法:
-   key 指向 entry 的值,也指向 Cache 中的链表节点
-   hashmap map[string]*list.Element 与 entry 中的 key string 相同
+   hashmap 保存 key 对应的 entry, 淘汰顺序由 EvictionPolicy 决定, 默认为 LRU-K
 */
 
 // Cache 是一个 LRU 缓存，不是并发安全的
 type Cache struct {
 	// 容量
 	capacity int64
+	// 最大条目数
+	maxEntries int
 	// 已使用的内存
 	nBytes   int64
-	ll       *list.List
-	hashmap  map[string]*list.Element
+	hashmap  map[string]*entry
+	expiry   expiryHeap // 设置了过期时间的记录, 见 RemoveExpired
+	policy   EvictionPolicy
 	callback OnEvicted
-	K        int           // 最近 K 次访问
-	TTL      time.Duration // 生存时间
+	// 因容量不足被淘汰的条目数, 不包括删除与过期
+	evictions int64
+	K         int           // 最近 K 次访问
+	TTL       time.Duration // 生存时间
+	// TTLJitter 是 TTL 随机缩短的最大比例, 取值 [0, 1), 使同时添加的记录不会同时过期, 见 NextTTL
+	TTLJitter float64
+	// IdleTTL 大于 0 时记录在 IdleTTL 内没有被访问就会过期, 每次命中都会延长过期时间,
+	// 但不会晚于添加时指定的过期时间, 此时 TTL 与 AddWithExpire 的 expireAt 是最长的生存时间
+	IdleTTL time.Duration
+	Grace   time.Duration // 过期后继续保留的时间, 期间 Get 不命中, 但 GetWithExpire 仍可取到旧值
+	// Admission 为 nil 时 AddIfAdmitted 与 AddWithExpire 相同, 否则 AddIfAdmitted 添加的新记录先进入准入窗口, 见 window.go
+	Admission AdmissionPolicy
+	// window 是准入窗口, 按 LRU 顺序保存 AddIfAdmitted 添加的新记录, windowBytes 为其使用的内存
+	window      *list.List
+	windowBytes int64
 }
 
 // OnEvicted 记录某条记录被移除时的回调函数
 type OnEvicted func(key string, value Value)
 
-// entry 是双向链表节点的数据类型
+// entry 是 hashmap 中的记录
 type entry struct {
-	key         string
-	value       Value
-	accessTimes []time.Time
-	expireAt    time.Time
+	key      string
+	value    Value
+	expireAt time.Time
+	deadline time.Time // 添加时指定的过期时间, IdleTTL 延长的过期时间不会超过它
+	index    int       // 在 expiry 中的下标, 不在堆中时为 -1
+	// 在准入窗口中的位置, 不在窗口中时为 nil, 此时由 EvictionPolicy 管理
+	window *list.Element
+	cost   float64 // 重新加载的代价, 记录离开准入窗口时交给 CostPolicy
 }
 
 // Value 是缓存值的抽象接口，Len() 返回值所占用的内存大小
@@ -47,118 +66,263 @@ type Value interface {
 	Len() int
 }
 
-type Config struct {
-	K   int           `json:"k"`
-	TTL time.Duration `json:"TTL"`
+// Options 是创建 Cache 的配置, 零值表示不限制容量, 永不过期
+type Options struct {
+	MaxBytes   int64          // 最大内存, 0 表示不限制
+	MaxEntries int            // 最大条目数, 0 表示不限制
+	K          int            // Policy 为 nil 时使用 LRU-K 淘汰, 为 0 时为 1
+	TTL        time.Duration  // Add 使用的生存时间, 0 表示永不过期
+	IdleTTL    time.Duration  // 空闲过期时间, 0 表示不会因空闲而过期, 见 Cache.IdleTTL
+	TTLJitter  float64        // TTL 随机缩短的最大比例, 见 Cache.TTLJitter
+	Policy     EvictionPolicy // 淘汰策略, 为 nil 时使用 NewLRUK(K)
+	OnEvicted  OnEvicted      // 记录被淘汰, 过期或删除时的回调
 }
 
-func readConfig() (Config, error) {
-	file, err := os.Open("config.json")
-	if err != nil {
-		return Config{}, err
+// Validate 检查配置是否合法
+func (o Options) Validate() error {
+	switch {
+	case o.MaxBytes < 0:
+		return fmt.Errorf("lru: negative MaxBytes %d", o.MaxBytes)
+	case o.MaxEntries < 0:
+		return fmt.Errorf("lru: negative MaxEntries %d", o.MaxEntries)
+	case o.K < 0:
+		return fmt.Errorf("lru: negative K %d", o.K)
+	case o.TTL < 0:
+		return fmt.Errorf("lru: negative TTL %s", o.TTL)
+	case o.IdleTTL < 0:
+		return fmt.Errorf("lru: negative IdleTTL %s", o.IdleTTL)
+	case o.TTLJitter < 0 || o.TTLJitter >= 1:
+		return fmt.Errorf("lru: TTLJitter %g out of range [0, 1)", o.TTLJitter)
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			return
-		}
-	}(file)
-
-	var config Config
-	err = json.NewDecoder(file).Decode(&config)
-	if err != nil {
-		return Config{}, err
-	}
-
-	return config, nil
+	return nil
 }
 
-func New(maxBytes int64, callback OnEvicted) *Cache {
-	config, err := readConfig()
-	if err != nil {
-		return nil
+// New 根据 opts 创建 Cache, 配置不合法时返回错误
+func New(opts Options) (*Cache, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
-	ttl, err := time.ParseDuration(config.TTL.String())
-	if err != nil {
-		return nil
+	k := max(opts.K, 1)
+	policy := opts.Policy
+	if policy == nil {
+		policy = NewLRUK(k)
 	}
 	return &Cache{
-		capacity: maxBytes,
-		ll:       list.New(),
-		hashmap:  make(map[string]*list.Element),
-		callback: callback,
-		K:        config.K,
-		TTL:      ttl,
-	}
+		capacity:   opts.MaxBytes,
+		maxEntries: opts.MaxEntries,
+		hashmap:    make(map[string]*entry),
+		policy:     policy,
+		callback:   opts.OnEvicted,
+		K:          k,
+		TTL:        opts.TTL,
+		IdleTTL:    opts.IdleTTL,
+		TTLJitter:  opts.TTLJitter,
+	}, nil
 }
 
 func (c *Cache) Get(key string) (value Value, ok bool) {
-	if ele, ok := c.hashmap[key]; ok {
-		kv := ele.Value.(*entry)
-		if kv.expireAt.Before(time.Now()) {
+	value, expireAt, ok := c.GetWithExpire(key)
+	if ok && !expireAt.IsZero() && expireAt.Before(time.Now()) {
+		// 处于保留期的旧值
+		return nil, false
+	}
+	return value, ok
+}
+
+// GetWithExpire 返回记录及其过期时间, 已过期但仍在 Grace 保留期内的记录也会返回
+// 超出保留期的记录会被删除
+func (c *Cache) GetWithExpire(key string) (value Value, expireAt time.Time, ok bool) {
+	if c.Admission != nil {
+		c.Admission.Record(key)
+	}
+	if kv, ok := c.hashmap[key]; ok {
+		now := time.Now()
+		if !kv.expireAt.IsZero() && kv.expireAt.Add(c.Grace).Before(now) {
 			c.Delete(key)
-			return nil, false
+			return nil, time.Time{}, false
 		}
-		kv.accessTimes = append(kv.accessTimes, time.Now())
-		if len(kv.accessTimes) > c.K {
-			kv.accessTimes = kv.accessTimes[1:]
-			c.ll.MoveToFront(ele)
+		if c.IdleTTL > 0 && (kv.expireAt.IsZero() || now.Before(kv.expireAt)) {
+			// 命中未过期的记录时延长过期时间, 保留期内的旧值不会被延长
+			c.setExpire(kv, c.slide(kv.deadline, now))
 		}
-		return kv.value, true
+		c.access(kv)
+		return kv.value, kv.expireAt, true
 	}
 	return
 }
 
 func (c *Cache) Delete(key string) bool {
-	if ele, ok := c.hashmap[key]; ok {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.hashmap, kv.key)
-		c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.callback != nil {
-			c.callback(kv.key, kv.value)
-		}
+	if kv, ok := c.hashmap[key]; ok {
+		c.remove(kv)
 		return true
 	}
 	return false
 }
 
+// RemoveOldest 淘汰 EvictionPolicy 选出的记录, 计入 Evictions; EvictionPolicy 中没有记录时淘汰准入窗口中的记录
 func (c *Cache) RemoveOldest() {
-	ele := c.ll.Back()
-	if ele != nil {
-		// 从链表中删除
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		// 从字典中删除
-		delete(c.hashmap, kv.key)
-		c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.callback != nil {
-			c.callback(kv.key, kv.value)
-		}
+	if kv := c.victim(); kv != nil {
+		c.evictions++
+		c.remove(kv)
+	}
+}
+
+// Evictions 返回被 RemoveOldest 淘汰的条目数, Delete 与过期删除的记录不计入
+func (c *Cache) Evictions() int64 {
+	return c.evictions
+}
+
+func (c *Cache) remove(kv *entry) {
+	c.setExpire(kv, time.Time{})
+	if kv.window != nil {
+		c.leaveWindow(kv)
+	} else {
+		c.policy.Remove(kv.key)
+	}
+	delete(c.hashmap, kv.key)
+	c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.callback != nil {
+		c.callback(kv.key, kv.value)
 	}
 }
 
+// Add 添加一条记录, 过期时间由 NextTTL 决定
 func (c *Cache) Add(key string, value Value) {
-	if ele, ok := c.hashmap[key]; ok {
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
+	var expireAt time.Time
+	if ttl := c.NextTTL(); ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	c.AddWithExpire(key, value, expireAt)
+}
+
+// NextTTL 返回下一条使用默认 TTL 添加的记录的生存时间, 在 TTL 的基础上随机缩短至多 TTLJitter 的比例
+// TTL 为 0 时返回 0, 表示永不过期
+func (c *Cache) NextTTL() time.Duration {
+	if c.TTL <= 0 || c.TTLJitter <= 0 {
+		return c.TTL
+	}
+	return c.TTL - time.Duration(rand.Float64()*c.TTLJitter*float64(c.TTL))
+}
+
+// AddWithExpire 添加一条记录并指定过期时间, expireAt 为零值表示永不过期
+// 已存在的记录会被更新, 并重新计算过期时间; 大小超过 MaxBytes 的记录不会被保存, 同名的旧值会被删除
+func (c *Cache) AddWithExpire(key string, value Value, expireAt time.Time) {
+	c.add(key, value, expireAt, 0, false)
+}
+
+// SetCost 设置 key 的重新加载代价, 淘汰策略实现了 CostPolicy 时生效; cost <= 0 表示未知, 不做修改
+func (c *Cache) SetCost(key string, cost float64) {
+	p, ok := c.policy.(CostPolicy)
+	if !ok || cost <= 0 {
+		return
+	}
+	if kv, ok := c.hashmap[key]; ok {
+		kv.cost = cost
+		if kv.window == nil {
+			p.SetCost(key, int64(len(kv.key))+int64(kv.value.Len()), cost)
+		}
+	}
+}
+
+// AddIfAdmitted 与 AddWithExpire 相同, 但设置了 Admission 时新记录先进入准入窗口,
+// 离开窗口时由 Admission 判断它能否替换 EvictionPolicy 选出的淘汰对象
+// cost 为重新加载的代价, 大于 0 时在淘汰其它记录之前设置, 见 CostPolicy; 返回记录是否被保存
+func (c *Cache) AddIfAdmitted(key string, value Value, expireAt time.Time, cost float64) bool {
+	return c.add(key, value, expireAt, cost, true)
+}
+
+// add 添加或更新一条记录, 超出全部容量的记录不会被保存, 也不会淘汰其它记录, 已存在的旧值会被删除
+// windowed 为 true 时新记录在设置了 Admission 时进入准入窗口
+func (c *Cache) add(key string, value Value, expireAt time.Time, cost float64, windowed bool) bool {
+	if c.capacity != 0 && int64(len(key))+int64(value.Len()) > c.capacity {
+		if kv, ok := c.hashmap[key]; ok {
+			c.remove(kv)
+		}
+		return false
+	}
+	if kv, ok := c.hashmap[key]; ok {
+		c.access(kv)
 		c.nBytes += int64(value.Len()) - int64(kv.value.Len())
+		if kv.window != nil {
+			c.windowBytes += int64(value.Len()) - int64(kv.value.Len())
+		}
 		kv.value = value
+		kv.deadline = expireAt
+		c.setExpire(kv, c.slide(expireAt, time.Now()))
 	} else {
-		expireAt := time.Now().Add(c.TTL)
-		ele := c.ll.PushFront(&entry{
-			key,
-			value,
-			[]time.Time{time.Now()},
-			expireAt})
-		c.hashmap[key] = ele
+		kv := &entry{key: key, value: value, deadline: expireAt, index: -1}
+		c.hashmap[key] = kv
+		c.setExpire(kv, c.slide(expireAt, time.Now()))
 		c.nBytes += int64(len(key)) + int64(value.Len())
+		if windowed && c.Admission != nil && (c.capacity != 0 || c.maxEntries != 0) {
+			c.enterWindow(kv)
+		} else {
+			c.policy.Add(key)
+		}
 	}
-	for c.capacity != 0 && c.capacity < c.nBytes {
+	if cost > 0 {
+		c.SetCost(key, cost)
+	}
+	c.drainWindow()
+	for len(c.hashmap) > 0 && c.full(0, 0) {
 		c.RemoveOldest()
 	}
+	return true
+}
+
+// full 判断再添加 bytes 字节与 entries 条记录后是否超出容量
+func (c *Cache) full(bytes int64, entries int) bool {
+	return c.capacity != 0 && c.nBytes+bytes > c.capacity ||
+		c.maxEntries != 0 && len(c.hashmap)+entries > c.maxEntries
 }
 
 func (c *Cache) Len() int {
-	return c.ll.Len()
+	return len(c.hashmap)
+}
+
+// Bytes 返回已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nBytes
+}
+
+// slide 返回在 now 被访问后的过期时间: 开启 IdleTTL 时为 now + IdleTTL, 但不晚于 deadline
+func (c *Cache) slide(deadline, now time.Time) time.Time {
+	if c.IdleTTL <= 0 {
+		return deadline
+	}
+	expireAt := now.Add(c.IdleTTL)
+	if !deadline.IsZero() && deadline.Before(expireAt) {
+		return deadline
+	}
+	return expireAt
+}
+
+// ExpireAt 返回记录当前的过期时间, 不会更新访问记录, 也不检查是否过期
+func (c *Cache) ExpireAt(key string) (expireAt time.Time, ok bool) {
+	if kv, ok := c.hashmap[key]; ok {
+		return kv.expireAt, true
+	}
+	return time.Time{}, false
+}
+
+// Contains 判断 key 是否存在, 不会更新访问记录, 也不检查是否过期
+func (c *Cache) Contains(key string) bool {
+	_, ok := c.hashmap[key]
+	return ok
+}
+
+// Walk 按淘汰顺序依次访问每条记录, 不会更新访问记录
+// 按访问顺序将记录依次 Add 到另一个 Cache 中即可还原 LRU 顺序
+func (c *Cache) Walk(fn func(key string, value Value, expireAt time.Time)) {
+	c.policy.Walk(func(key string) {
+		kv := c.hashmap[key]
+		fn(kv.key, kv.value, kv.expireAt)
+	})
+	// 准入窗口中的记录是最近添加的, 最后访问
+	if c.window != nil {
+		for ele := c.window.Back(); ele != nil; ele = ele.Prev() {
+			kv := ele.Value.(*entry)
+			fn(kv.key, kv.value, kv.expireAt)
+		}
+	}
 }
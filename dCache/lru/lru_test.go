@@ -6,8 +6,12 @@
 package lru
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -16,9 +20,77 @@ func (d String) Len() int {
 	return len(d)
 }
 
+// mustNew 使用合法的配置创建 Cache
+func mustNew(opts Options) *Cache {
+	c, err := New(opts)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	for _, opts := range []Options{{MaxBytes: -1}, {MaxEntries: -1}, {K: -1}, {TTL: -time.Second}} {
+		if c, err := New(opts); err == nil || c != nil {
+			t.Fatalf("invalid options %+v should return an error", opts)
+		}
+	}
+
+	c := mustNew(Options{MaxEntries: 2, TTL: time.Hour})
+	for _, k := range []string{"k1", "k2", "k3"} {
+		c.Add(k, String(k))
+	}
+	if c.Len() != 2 || c.Contains("k1") {
+		t.Fatalf("MaxEntries should evict the oldest entry, but %d entries left", c.Len())
+	}
+	if _, expireAt, _ := c.GetWithExpire("k3"); time.Until(expireAt) <= 59*time.Minute {
+		t.Fatalf("Add should use the TTL in options, but expires at %s", expireAt)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	want := Config{K: 2, TTL: "10s", IdleTTL: "1s", TTLJitter: 0.1, MaxEntries: 100}
+	for _, path := range []string{
+		write("config.json", `{"k": 2, "TTL": "10s", "idleTTL": "1s", "ttlJitter": 0.1, "maxEntries": 100}`),
+		write("config.yaml", "k: 2\nttl: 10s\nidleTTL: 1s\nttlJitter: 0.1\nmaxEntries: 100\n"),
+	} {
+		config, err := LoadConfig(path)
+		if err != nil || config != want {
+			t.Fatalf("%s: expect %+v, but %+v got, err %v", path, want, config, err)
+		}
+		if opts, _ := config.Options(); opts.TTL != 10*time.Second || opts.IdleTTL != time.Second || opts.K != 2 || opts.MaxEntries != 100 {
+			t.Fatalf("%s: unexpected options %+v", path, opts)
+		}
+	}
+
+	for _, path := range []string{
+		write("typo.json", `{"ttl": "10s", "maxEntry": 100}`),
+		write("typo.yml", "maxEntry: 100\n"),
+		write("ttl.json", `{"TTL": "10"}`),
+		write("idle.yaml", "idleTTL: -1s\n"),
+		write("jitter.json", `{"ttlJitter": 2}`),
+		write("negative.yaml", "k: -1\n"),
+		write("config.toml", "k = 2\n"),
+		filepath.Join(dir, "missing.json"),
+	} {
+		if _, err := LoadConfig(path); err == nil {
+			t.Fatalf("load %s should fail", path)
+		}
+	}
+}
+
 func TestCache_Get(t *testing.T) {
 	// 0 代表不限制内存大小
-	lru := New(int64(0), nil)
+	lru := mustNew(Options{})
 	lru.Add("key1", String("1234"))
 	if v, ok := lru.Get("key1"); !ok || string(v.(String)) != "1234" {
 		t.Fatalf("hashmap hit key1=1234 failed")
@@ -32,7 +104,7 @@ func TestCache_RemoveOldest(t *testing.T) {
 	k1, k2, k3 := "key1", "key2", "k3"
 	v1, v2, v3 := "value1", "value2", "3"
 	capacity := len(k1 + k2 + v1 + v2)
-	lru := New(int64(capacity), nil)
+	lru := mustNew(Options{MaxBytes: int64(capacity)})
 	lru.Add(k1, String(v1))
 	lru.Add(k2, String(v2))
 	lru.Add(k3, String(v3))
@@ -41,12 +113,28 @@ func TestCache_RemoveOldest(t *testing.T) {
 	}
 }
 
+func TestCache_Oversize(t *testing.T) {
+	lru := mustNew(Options{MaxBytes: 10})
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	if lru.AddIfAdmitted("big", String("0123456789"), time.Time{}, 0) {
+		t.Fatalf("entry larger than MaxBytes should not be saved")
+	}
+	if lru.Len() != 2 || lru.Evictions() != 0 {
+		t.Fatalf("oversized entry should not evict others, %d entries, %d evictions", lru.Len(), lru.Evictions())
+	}
+	lru.Add("k1", String("0123456789"))
+	if _, ok := lru.Get("k1"); ok || lru.Len() != 1 || lru.Bytes() != 4 || lru.Evictions() != 0 {
+		t.Fatalf("old value should be removed when updated to an oversized one")
+	}
+}
+
 func TestCache_TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
 	callback := func(key string, value Value) {
 		keys = append(keys, key)
 	}
-	lru := New(int64(10), callback)
+	lru := mustNew(Options{MaxBytes: 10, OnEvicted: callback})
 	lru.Add("key1", String("123456"))
 	lru.Add("k2", String("v2"))
 	lru.Add("k3", String("v3"))
@@ -58,3 +146,151 @@ func TestCache_TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestCache_Evictions(t *testing.T) {
+	lru := mustNew(Options{MaxBytes: 10})
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	lru.Delete("k2")
+	lru.AddWithExpire("k3", String("v3"), time.Now().Add(-time.Second))
+	lru.RemoveExpired(time.Now(), 0)
+	if n := lru.Evictions(); n != 0 {
+		t.Fatalf("deleted and expired entries should not be counted as evictions, but %d got", n)
+	}
+	lru.Add("k4", String("v4"))
+	lru.Add("k5", String("v5"))
+	if n := lru.Evictions(); n != 1 {
+		t.Fatalf("entries evicted for capacity should be counted, expect 1, but %d got", n)
+	}
+}
+
+func TestCache_AddWithExpire(t *testing.T) {
+	lru := mustNew(Options{})
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 {
+		t.Fatalf("expired key1 should be removed")
+	}
+	lru.AddWithExpire("key2", String("1234"), time.Time{})
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 without expiration should hit")
+	}
+}
+
+func TestCache_Grace(t *testing.T) {
+	lru := mustNew(Options{})
+	lru.Grace = time.Hour
+	expireAt := time.Now().Add(-time.Second)
+	lru.AddWithExpire("key1", String("1234"), expireAt)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("expired key1 should miss")
+	}
+	if v, at, ok := lru.GetWithExpire("key1"); !ok || string(v.(String)) != "1234" || !at.Equal(expireAt) {
+		t.Fatalf("expired key1 within grace should be returned")
+	}
+
+	lru.Grace = 0
+	if _, _, ok := lru.GetWithExpire("key1"); ok || lru.Len() != 0 {
+		t.Fatalf("key1 beyond grace should be removed")
+	}
+}
+
+func TestCache_Walk(t *testing.T) {
+	lru := mustNew(Options{})
+	for _, k := range []string{"k1", "k2", "k3"} {
+		lru.Add(k, String(k))
+	}
+	var keys []string
+	lru.Walk(func(key string, value Value, expireAt time.Time) {
+		keys = append(keys, key)
+	})
+	if !reflect.DeepEqual(keys, []string{"k1", "k2", "k3"}) || !lru.Contains("k2") || lru.Contains("k4") {
+		t.Fatalf("Walk should visit entries from the oldest, but %v got", keys)
+	}
+}
+
+func TestCache_RemoveExpired(t *testing.T) {
+	var evicted []string
+	lru := mustNew(Options{OnEvicted: func(key string, value Value) {
+		evicted = append(evicted, key)
+	}})
+	lru.Grace = time.Minute
+	now := time.Now()
+	lru.AddWithExpire("k1", String("1"), now.Add(-2*time.Minute))
+	lru.AddWithExpire("k2", String("2"), now.Add(-3*time.Minute))
+	lru.AddWithExpire("k3", String("3"), now.Add(-time.Second)) // 处于保留期内
+	lru.AddWithExpire("k4", String("4"), time.Time{})
+	lru.AddWithExpire("k5", String("5"), now.Add(-time.Hour))
+	lru.AddWithExpire("k5", String("5"), now.Add(time.Hour)) // 更新后不再过期
+
+	if next, ok := lru.NextExpiry(); !ok || !next.Equal(now.Add(-3*time.Minute)) {
+		t.Fatalf("NextExpiry should return the earliest expiration, but %s got", next)
+	}
+	if n := lru.RemoveExpired(now, 1); n != 1 || !reflect.DeepEqual(evicted, []string{"k2"}) {
+		t.Fatalf("RemoveExpired should respect limit and remove the earliest first, %d removed, %v", n, evicted)
+	}
+	if n := lru.RemoveExpired(now, 0); n != 1 || !reflect.DeepEqual(evicted, []string{"k2", "k1"}) {
+		t.Fatalf("only entries beyond grace should be removed, %d removed, %v", n, evicted)
+	}
+	if lru.Len() != 3 || lru.Bytes() != 9 {
+		t.Fatalf("unexpected cache size: %d entries, %d bytes", lru.Len(), lru.Bytes())
+	}
+
+	lru.Delete("k3")
+	lru.Delete("k5")
+	if _, ok := lru.NextExpiry(); ok {
+		t.Fatalf("deleted entries should leave the expiry heap")
+	}
+}
+
+func TestCache_IdleTTL(t *testing.T) {
+	lru := mustNew(Options{IdleTTL: 100 * time.Millisecond})
+	lru.AddWithExpire("key1", String("1"), time.Time{})
+	// 每次命中都延长过期时间, 总的存活时间可以超过 IdleTTL
+	for i := 0; i < 3; i++ {
+		time.Sleep(40 * time.Millisecond)
+		if _, ok := lru.Get("key1"); !ok {
+			t.Fatalf("key1 read within IdleTTL should not expire")
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("idle key1 should expire")
+	}
+
+	// 添加时指定的过期时间是最长的生存时间
+	lru.IdleTTL = time.Hour
+	deadline := time.Now().Add(time.Minute)
+	lru.AddWithExpire("key2", String("2"), deadline)
+	if _, at, _ := lru.GetWithExpire("key2"); !at.Equal(deadline) {
+		t.Fatalf("sliding expiration should not exceed the deadline %s, but %s got", deadline, at)
+	}
+	lru.TTL = 2 * time.Hour
+	lru.Add("key3", String("3"))
+	at, _ := lru.ExpireAt("key3")
+	if d := time.Until(at); d > time.Hour || d < 59*time.Minute {
+		t.Fatalf("key3 should expire after IdleTTL, but %s got", d)
+	}
+}
+
+func TestCache_TTLJitter(t *testing.T) {
+	if _, err := New(Options{TTLJitter: 1}); err == nil {
+		t.Fatalf("TTLJitter out of range should return an error")
+	}
+
+	lru := mustNew(Options{TTL: time.Hour, TTLJitter: 0.2})
+	start := time.Now()
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		lru.Add(key, String("v"))
+		at, _ := lru.ExpireAt(key)
+		ttl := at.Sub(start)
+		if ttl > time.Hour+time.Second || ttl < 48*time.Minute {
+			t.Fatalf("jittered TTL should be within [0.8, 1] * TTL, but %s got", ttl)
+		}
+		seen[ttl.Truncate(time.Minute)] = true
+	}
+	if len(seen) < 5 {
+		t.Fatalf("entries added together should not expire together")
+	}
+}
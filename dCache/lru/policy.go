@@ -0,0 +1,416 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy 决定 Cache 中记录被淘汰的顺序, 只记录 key, 值与过期时间由 Cache 保存
+// Cache 保证 Add 的 key 不存在, Access 与 Remove 的 key 存在; 实现不需要是并发安全的
+type EvictionPolicy interface {
+	// Add 记录一个新加入的 key
+	Add(key string)
+	// Access 记录一次对 key 的访问, 包括更新已存在的 key
+	Access(key string)
+	// Remove 删除 key, 包括被淘汰的 key
+	Remove(key string)
+	// Victim 返回下一个应被淘汰的 key, 不会删除它; 没有记录时 ok 为 false
+	Victim() (key string, ok bool)
+	// Walk 按淘汰顺序访问全部 key, 先被淘汰的先访问
+	Walk(fn func(key string))
+}
+
+// LRU 淘汰最久未被访问的记录
+type LRU struct {
+	ll    *list.List
+	elems map[string]*list.Element
+}
+
+func NewLRU() *LRU {
+	return &LRU{ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *LRU) Add(key string) {
+	p.elems[key] = p.ll.PushFront(key)
+}
+
+func (p *LRU) Access(key string) {
+	p.ll.MoveToFront(p.elems[key])
+}
+
+func (p *LRU) Remove(key string) {
+	if ele, ok := p.elems[key]; ok {
+		p.ll.Remove(ele)
+		delete(p.elems, key)
+	}
+}
+
+func (p *LRU) Victim() (string, bool) {
+	if ele := p.ll.Back(); ele != nil {
+		return ele.Value.(string), true
+	}
+	return "", false
+}
+
+func (p *LRU) Walk(fn func(key string)) {
+	for ele := p.ll.Back(); ele != nil; ele = ele.Prev() {
+		fn(ele.Value.(string))
+	}
+}
+
+/*
+   LRU-K: https://dl.acm.org/doi/10.1145/170036.170081
+   按第 K 近的访问时间 (backward K-distance) 淘汰记录, 第 K 近的访问越早越先被淘汰
+   访问不足 K 次的记录的 K-distance 为无穷大, 保存在单独的 history 队列中按 LRU 顺序先被淘汰,
+   只被访问过一次的扫描数据因此不会挤掉经常被访问的记录
+   被移除的 key 的访问历史会保留一段时间, 再次加入时继续累计访问次数, 保留的数量不超过当前的记录数
+*/
+
+// minRetained 是 LRUK 至少保留访问历史的已移除 key 的数量
+const minRetained = 64
+
+// LRUK 淘汰第 K 近的访问最早的记录, 访问不足 K 次的记录先被淘汰; K 为 1 时与 LRU 相同
+type LRUK struct {
+	k        int
+	clock    int64                 // 访问序号, 用作访问时间
+	history  *list.List            // 访问不足 k 次的记录, 队尾最久未被访问
+	h        priorityHeap          // 访问达到 k 次的记录, 按第 k 近的访问时间排序
+	elems    map[string]*lrukEntry // 当前的记录
+	retained *list.List            // 已移除的 key 的访问历史, 队尾最早被移除
+	ghosts   map[string]*lrukEntry // retained 中的 key
+}
+
+// lrukEntry 是一个 key 的访问历史
+type lrukEntry struct {
+	key   string
+	times []int64       // 最近的访问时间, 最多 k 个, 从早到晚
+	ele   *list.Element // 在 history 或 retained 中的位置
+	he    *heapEntry    // 在 h 中的位置
+}
+
+func NewLRUK(k int) *LRUK {
+	if k < 1 {
+		k = 1
+	}
+	return &LRUK{
+		k:        k,
+		history:  list.New(),
+		elems:    make(map[string]*lrukEntry),
+		retained: list.New(),
+		ghosts:   make(map[string]*lrukEntry),
+	}
+}
+
+func (p *LRUK) Add(key string) {
+	e, ok := p.ghosts[key]
+	if ok {
+		p.retained.Remove(e.ele)
+		delete(p.ghosts, key)
+		e.ele = nil
+	} else {
+		e = &lrukEntry{key: key}
+	}
+	p.elems[key] = e
+	p.record(e)
+}
+
+func (p *LRUK) Access(key string) {
+	p.record(p.elems[key])
+}
+
+// record 记录一次访问, 并将 e 移动到对应的位置
+func (p *LRUK) record(e *lrukEntry) {
+	p.clock++
+	if len(e.times) == p.k {
+		copy(e.times, e.times[1:])
+		e.times[p.k-1] = p.clock
+	} else {
+		e.times = append(e.times, p.clock)
+	}
+
+	switch {
+	case len(e.times) < p.k && e.ele != nil:
+		p.history.MoveToFront(e.ele)
+	case len(e.times) < p.k:
+		e.ele = p.history.PushFront(e)
+	case e.he != nil:
+		e.he.priority = float64(e.times[0])
+		heap.Fix(&p.h, e.he.index)
+	default:
+		if e.ele != nil {
+			p.history.Remove(e.ele)
+			e.ele = nil
+		}
+		e.he = &heapEntry{key: e.key, priority: float64(e.times[0])}
+		heap.Push(&p.h, e.he)
+	}
+}
+
+func (p *LRUK) Remove(key string) {
+	e, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	delete(p.elems, key)
+	if e.he != nil {
+		heap.Remove(&p.h, e.he.index)
+		e.he = nil
+	} else {
+		p.history.Remove(e.ele)
+	}
+
+	e.ele = p.retained.PushFront(e)
+	p.ghosts[key] = e
+	for p.retained.Len() > max(len(p.elems), minRetained) {
+		oldest := p.retained.Remove(p.retained.Back()).(*lrukEntry)
+		delete(p.ghosts, oldest.key)
+	}
+}
+
+func (p *LRUK) Victim() (string, bool) {
+	if ele := p.history.Back(); ele != nil {
+		return ele.Value.(*lrukEntry).key, true
+	}
+	return p.h.victim()
+}
+
+func (p *LRUK) Walk(fn func(key string)) {
+	for ele := p.history.Back(); ele != nil; ele = ele.Prev() {
+		fn(ele.Value.(*lrukEntry).key)
+	}
+	p.h.walk(fn)
+}
+
+/*
+   LFU 使用动态老化 (LFU-DA):
+   记录的优先级为 访问次数 + age, age 为最近一次被淘汰的记录的优先级
+   新记录继承当前的 age, 过去访问频繁但不再被访问的记录会逐渐被淘汰
+*/
+
+// LFU 淘汰优先级最低的记录, 优先级相同时淘汰最久未被访问的记录
+type LFU struct {
+	h     priorityHeap
+	elems map[string]*heapEntry
+	age   float64
+	clock int64 // 访问序号, 用于在优先级相同时比较访问时间
+}
+
+func NewLFU() *LFU {
+	return &LFU{elems: make(map[string]*heapEntry)}
+}
+
+func (p *LFU) Add(key string) {
+	p.clock++
+	e := &heapEntry{key: key, priority: p.age + 1, seq: p.clock}
+	p.elems[key] = e
+	heap.Push(&p.h, e)
+}
+
+func (p *LFU) Access(key string) {
+	p.clock++
+	e := p.elems[key]
+	e.priority++
+	// 老化后 age 可能已超过旧的优先级, 被访问的记录至少与新记录相同
+	e.priority = max(e.priority, p.age+1)
+	e.seq = p.clock
+	heap.Fix(&p.h, e.index)
+}
+
+func (p *LFU) Remove(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.age = p.h.remove(e, p.age)
+		delete(p.elems, key)
+	}
+}
+
+func (p *LFU) Victim() (string, bool) {
+	return p.h.victim()
+}
+
+func (p *LFU) Walk(fn func(key string)) {
+	p.h.walk(fn)
+}
+
+/*
+   GreedyDual-Size: https://www.usenix.org/legacy/publications/library/proceedings/usits97/full_papers/cao/cao.pdf
+   记录的优先级为 L + cost/size, L 为最近一次被淘汰的记录的优先级, 每次访问时重新计算
+   重新加载代价高, 占用内存少的记录更不容易被淘汰, 长时间未被访问的记录随 L 的增长逐渐被淘汰
+*/
+
+// CostPolicy 是可以感知记录大小与重新加载代价的淘汰策略
+type CostPolicy interface {
+	EvictionPolicy
+	// SetCost 设置 key 的大小与重新加载的代价, 未设置时 cost/size 视为已设置的记录的平均值
+	SetCost(key string, size int64, cost float64)
+}
+
+// GreedyDualSize 淘汰 L + cost/size 最低的记录, 优先级相同时淘汰最久未被访问的记录
+// 代价未知的记录使用此前设置过的全部 cost/size 的平均值, 尚未设置过时为 0
+type GreedyDualSize struct {
+	h     priorityHeap
+	elems map[string]*heapEntry
+	l     float64
+	clock int64
+	// sum 与 n 为设置过的 cost/size 之和与次数
+	sum float64
+	n   int64
+}
+
+func NewGreedyDualSize() *GreedyDualSize {
+	return &GreedyDualSize{elems: make(map[string]*heapEntry)}
+}
+
+func (p *GreedyDualSize) Add(key string) {
+	p.clock++
+	e := &heapEntry{key: key, weight: p.meanWeight(), seq: p.clock}
+	e.priority = p.l + e.weight
+	p.elems[key] = e
+	heap.Push(&p.h, e)
+}
+
+func (p *GreedyDualSize) Access(key string) {
+	p.clock++
+	e := p.elems[key]
+	if !e.known {
+		e.weight = p.meanWeight()
+	}
+	e.priority = p.l + e.weight
+	e.seq = p.clock
+	heap.Fix(&p.h, e.index)
+}
+
+func (p *GreedyDualSize) SetCost(key string, size int64, cost float64) {
+	e, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	e.weight = cost / float64(max(size, 1))
+	e.known = true
+	e.priority = p.l + e.weight
+	heap.Fix(&p.h, e.index)
+	p.sum += e.weight
+	p.n++
+}
+
+// meanWeight 返回代价未知的记录使用的 cost/size
+func (p *GreedyDualSize) meanWeight() float64 {
+	if p.n == 0 {
+		return 0
+	}
+	return p.sum / float64(p.n)
+}
+
+func (p *GreedyDualSize) Remove(key string) {
+	if e, ok := p.elems[key]; ok {
+		p.l = p.h.remove(e, p.l)
+		delete(p.elems, key)
+	}
+}
+
+func (p *GreedyDualSize) Victim() (string, bool) {
+	return p.h.victim()
+}
+
+func (p *GreedyDualSize) Walk(fn func(key string)) {
+	p.h.walk(fn)
+}
+
+// heapEntry 是 priorityHeap 中的记录
+type heapEntry struct {
+	key      string
+	priority float64
+	weight   float64 // GreedyDualSize 使用的 cost/size
+	known    bool    // GreedyDualSize 中 weight 是否由 SetCost 设置
+	seq      int64
+	index    int
+}
+
+// priorityHeap 是按 priority 与 seq 排序的最小堆, 堆顶为下一个被淘汰的记录
+type priorityHeap []*heapEntry
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool { return h.less(i, j) }
+
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *priorityHeap) Push(x any) {
+	e := x.(*heapEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *priorityHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+func (h priorityHeap) less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) victim() (string, bool) {
+	if len(h) == 0 {
+		return "", false
+	}
+	return h[0].key, true
+}
+
+// remove 删除 e, 返回新的 age: 被淘汰的记录位于堆顶, 用其优先级更新 age
+func (h *priorityHeap) remove(e *heapEntry, age float64) float64 {
+	if e.index == 0 {
+		age = e.priority
+	}
+	heap.Remove(h, e.index)
+	return age
+}
+
+// walk 按出堆顺序访问全部记录, 不修改堆
+func (h priorityHeap) walk(fn func(key string)) {
+	entries := make(priorityHeap, len(h))
+	copy(entries, h)
+	for len(entries) > 0 {
+		e := entries[0]
+		// 在副本上出堆, 不能修改原记录的 index
+		n := len(entries) - 1
+		entries[0], entries[n] = entries[n], entries[0]
+		entries = entries[:n]
+		entries.down(0)
+		fn(e.key)
+	}
+}
+
+// down 与 heap.Fix 相同, 但不会修改记录的 index
+func (h priorityHeap) down(i int) {
+	for {
+		l := 2*i + 1
+		if l >= len(h) {
+			return
+		}
+		j := l
+		if r := l + 1; r < len(h) && h.less(r, l) {
+			j = r
+		}
+		if !h.less(j, i) {
+			return
+		}
+		h[i], h[j] = h[j], h[i]
+		i = j
+	}
+}
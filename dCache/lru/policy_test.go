@@ -0,0 +1,220 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// policies 是共享行为测试覆盖的全部淘汰策略
+var policies = map[string]func() EvictionPolicy{
+	"LRU":  func() EvictionPolicy { return NewLRU() },
+	"LRUK": func() EvictionPolicy { return NewLRUK(2) },
+	"LFU":  func() EvictionPolicy { return NewLFU() },
+	"GDS":  func() EvictionPolicy { return NewGreedyDualSize() },
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			testPolicyBasic(t, newPolicy())
+			testPolicyKeepsAccessed(t, newPolicy())
+			testPolicyWalk(t, newPolicy())
+			testPolicyCache(t, newPolicy)
+		})
+	}
+}
+
+// testPolicyBasic: 空策略没有淘汰对象, 被删除的 key 不会被选中
+func testPolicyBasic(t *testing.T, p EvictionPolicy) {
+	if _, ok := p.Victim(); ok {
+		t.Fatalf("empty policy should have no victim")
+	}
+	p.Add("k1")
+	p.Add("k2")
+	if key, ok := p.Victim(); !ok || key != "k1" {
+		t.Fatalf("the oldest key without access should be evicted first, but %s got", key)
+	}
+	p.Remove("k1")
+	if key, ok := p.Victim(); !ok || key != "k2" {
+		t.Fatalf("removed key should not be the victim, but %s got", key)
+	}
+	p.Remove("k2")
+	if _, ok := p.Victim(); ok {
+		t.Fatalf("policy should be empty after removing all keys")
+	}
+}
+
+// testPolicyKeepsAccessed: 被多次访问的 key 不会先于从未被访问的 key 被淘汰
+func testPolicyKeepsAccessed(t *testing.T, p EvictionPolicy) {
+	p.Add("hot")
+	p.Add("cold")
+	for i := 0; i < 3; i++ {
+		p.Access("hot")
+	}
+	if key, _ := p.Victim(); key != "cold" {
+		t.Fatalf("accessed key should be kept, but %s is the victim", key)
+	}
+}
+
+// testPolicyWalk: Walk 访问全部 key 各一次, 第一个就是 Victim, 且不改变淘汰顺序
+func testPolicyWalk(t *testing.T, p EvictionPolicy) {
+	for i := 0; i < 10; i++ {
+		p.Add(fmt.Sprint(i))
+		if i%3 == 0 {
+			p.Access(fmt.Sprint(i))
+		}
+	}
+	var keys []string
+	p.Walk(func(key string) { keys = append(keys, key) })
+	victim, _ := p.Victim()
+	if len(keys) == 0 || keys[0] != victim {
+		t.Fatalf("Walk should start from the victim %s, but %v got", victim, keys)
+	}
+
+	var evicted []string
+	for {
+		key, ok := p.Victim()
+		if !ok {
+			break
+		}
+		evicted = append(evicted, key)
+		p.Remove(key)
+	}
+	if !reflect.DeepEqual(keys, evicted) {
+		t.Fatalf("Walk order %v should equal eviction order %v", keys, evicted)
+	}
+	sort.Strings(keys)
+	if len(keys) != 10 || keys[0] != "0" || keys[9] != "9" {
+		t.Fatalf("Walk should visit every key once, but %v got", keys)
+	}
+}
+
+// testPolicyCache: 作为 Cache 的淘汰策略时, 容量不会被超出, 回调收到被淘汰的记录
+func testPolicyCache(t *testing.T, newPolicy func() EvictionPolicy) {
+	var evicted []string
+	c := mustNew(Options{MaxBytes: 20, Policy: newPolicy(), OnEvicted: func(key string, value Value) {
+		evicted = append(evicted, key)
+	}})
+	for i := 0; i < 10; i++ {
+		c.Add(fmt.Sprintf("k%d", i), String("v"))
+		if c.Bytes() > 20 {
+			t.Fatalf("capacity exceeded: %d bytes", c.Bytes())
+		}
+		if _, ok := c.Get("k0"); !ok {
+			t.Fatalf("frequently accessed k0 should stay in cache")
+		}
+	}
+	if c.Len() != 6 || len(evicted) != 4 || evicted[0] == "k0" {
+		t.Fatalf("unexpected eviction: len %d, evicted %v", c.Len(), evicted)
+	}
+}
+
+func TestGreedyDualSize(t *testing.T) {
+	p := NewGreedyDualSize()
+	p.Add("slow")
+	p.SetCost("slow", 10, 3000)
+	p.Add("fast")
+	p.SetCost("fast", 10, 5)
+	p.Add("big")
+	p.SetCost("big", 10000, 3000)
+	p.Access("fast")
+	if key, _ := p.Victim(); key != "big" {
+		t.Fatalf("large entry should be evicted first, but %s got", key)
+	}
+	p.Remove("big")
+	if key, _ := p.Victim(); key != "fast" {
+		t.Fatalf("cheap entry should be evicted before the expensive one, but %s got", key)
+	}
+
+	// 被淘汰的记录抬高了 L, 长时间未被访问的昂贵记录最终也会被淘汰
+	p.Remove("fast")
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprint(i)
+		p.Add(key)
+		p.SetCost(key, 1, 2)
+		if victim, _ := p.Victim(); victim == "slow" {
+			return
+		}
+		p.Remove(key)
+	}
+	t.Fatalf("unused expensive entry should age out")
+}
+
+func TestGreedyDualSize_UnknownCost(t *testing.T) {
+	p := NewGreedyDualSize()
+	p.Add("slow")
+	p.SetCost("slow", 10, 3)
+	p.Add("fast")
+	p.SetCost("fast", 10, 1e-3)
+	// 代价未知的记录使用平均值, 不会比昂贵的记录更晚被淘汰
+	p.Add("unknown")
+	var keys []string
+	p.Walk(func(key string) { keys = append(keys, key) })
+	if !reflect.DeepEqual(keys, []string{"fast", "unknown", "slow"}) {
+		t.Fatalf("unknown cost should use the mean cost/size, but %v got", keys)
+	}
+}
+
+func TestLRUK(t *testing.T) {
+	p := NewLRUK(2)
+	// 访问顺序 a a b b c a a: a 与 b 第 2 近的访问分别是第 6 次与第 3 次, c 只被访问一次
+	for _, key := range []string{"a", "b"} {
+		p.Add(key)
+		p.Access(key)
+	}
+	p.Add("c")
+	p.Access("a")
+	p.Access("a")
+	var keys []string
+	p.Walk(func(key string) { keys = append(keys, key) })
+	if !reflect.DeepEqual(keys, []string{"c", "b", "a"}) {
+		t.Fatalf("keys seen less than K times should be evicted first, then by K-th access, but %v got", keys)
+	}
+
+	// 被移除的 key 保留访问历史, 重新加入后只需一次访问即可离开 history 队列
+	p.Remove("c")
+	p.Add("c")
+	p.Add("d")
+	if key, _ := p.Victim(); key != "d" {
+		t.Fatalf("retained history of c should be reused, but %s is the victim", key)
+	}
+
+	// 保留的访问历史不超过 max(记录数, minRetained)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		p.Add(key)
+		p.Remove(key)
+	}
+	if p.retained.Len() != minRetained || len(p.ghosts) != minRetained {
+		t.Fatalf("retained history should be capped, but %d got", p.retained.Len())
+	}
+}
+
+// TestLRUK_Traces 在相同的 trace 与容量下比较 LRU-K 与 LRU 的命中率
+func TestLRUK_Traces(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 1<<16)
+	trace := make([]string, 1<<15)
+	for i := range trace {
+		trace[i] = fmt.Sprint(zipf.Uint64())
+	}
+	for name, trace := range map[string][]string{"Zipf": trace, "Scan": scanWorkload(20000)} {
+		lru := hitRatio(mustNew(Options{MaxEntries: 100, Policy: NewLRU()}), trace)
+		lru1 := hitRatio(mustNew(Options{MaxEntries: 100, Policy: NewLRUK(1)}), trace)
+		lru2 := hitRatio(mustNew(Options{MaxEntries: 100, Policy: NewLRUK(2)}), trace)
+		if lru1 != lru {
+			t.Fatalf("%s: LRU-1 should behave like LRU, hit ratio %.3f vs %.3f", name, lru1, lru)
+		}
+		if lru2 < lru+0.05 {
+			t.Fatalf("%s: LRU-2 should beat LRU, hit ratio %.3f vs %.3f", name, lru2, lru)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+/*
+   TinyLFU 准入策略: https://arxiv.org/abs/1512.00727
+   count-min sketch 估算每个 key 最近的访问频率, 只有频率高于淘汰对象的新记录才会进入缓存
+   doorkeeper 是一个布隆过滤器, key 第一次出现时只记录在 doorkeeper 中, 不占用 sketch 的计数
+   访问次数达到采样上限后, 所有计数减半并清空 doorkeeper, 使频率反映最近的访问
+   作为 Cache.Admission 使用时新记录先进入准入窗口, 组成 W-TinyLFU, 见 window.go
+*/
+
+// AdmissionPolicy 决定缓存已满时新记录能否替换淘汰对象; 实现不需要是并发安全的
+type AdmissionPolicy interface {
+	// Record 记录一次对 key 的访问, 包括未命中的访问
+	Record(key string)
+	// Admit 判断 candidate 是否比 victim 更值得保留
+	Admit(candidate, victim string) bool
+}
+
+const (
+	sketchDepth  = 4
+	maxFrequency = 15 // 每个计数器的上限
+)
+
+// TinyLFU 是基于 count-min sketch 与 doorkeeper 的准入策略
+type TinyLFU struct {
+	seed       maphash.Seed
+	mask       uint64
+	sketch     [sketchDepth][]uint8
+	doorkeeper []uint64 // 布隆过滤器的位图
+	additions  int      // 自上次老化以来的访问次数
+	sampleSize int      // 达到该访问次数后老化
+}
+
+// NewTinyLFU 创建一个 TinyLFU, counters 应接近缓存中的预期条目数
+func NewTinyLFU(counters int) *TinyLFU {
+	width := uint64(1) << bits.Len(uint(max(counters, 64)-1))
+	t := &TinyLFU{
+		seed:       maphash.MakeSeed(),
+		mask:       width - 1,
+		doorkeeper: make([]uint64, width/64),
+		sampleSize: 10 * int(width),
+	}
+	for i := range t.sketch {
+		t.sketch[i] = make([]uint8, width)
+	}
+	return t
+}
+
+func (t *TinyLFU) Record(key string) {
+	h := maphash.String(t.seed, key)
+	if t.addToDoorkeeper(h) {
+		t.increment(h)
+	}
+	t.additions++
+	if t.additions >= t.sampleSize {
+		t.reset()
+	}
+}
+
+// Admit 在 candidate 的估计频率高于 victim 时返回 true, 频率相同时保留 victim
+func (t *TinyLFU) Admit(candidate, victim string) bool {
+	return t.Estimate(candidate) > t.Estimate(victim)
+}
+
+// Estimate 返回 key 最近访问频率的估计值
+func (t *TinyLFU) Estimate(key string) int {
+	h := maphash.String(t.seed, key)
+	n := uint8(maxFrequency)
+	for i := range t.sketch {
+		n = min(n, t.sketch[i][t.index(h, i)])
+	}
+	if t.inDoorkeeper(h) {
+		n++
+	}
+	return int(n)
+}
+
+// index 使用 double hashing 为第 i 行计算下标
+func (t *TinyLFU) index(h uint64, i int) uint64 {
+	h1, h2 := h&0xffffffff, h>>32|1
+	return (h1 + uint64(i)*h2) & t.mask
+}
+
+func (t *TinyLFU) increment(h uint64) {
+	for i := range t.sketch {
+		if c := &t.sketch[i][t.index(h, i)]; *c < maxFrequency {
+			*c++
+		}
+	}
+}
+
+// addToDoorkeeper 将 h 加入 doorkeeper, 已存在时返回 true
+func (t *TinyLFU) addToDoorkeeper(h uint64) bool {
+	seen := true
+	for i := 0; i < 2; i++ {
+		bit := t.index(h, sketchDepth+i)
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if t.doorkeeper[word]&mask == 0 {
+			seen = false
+			t.doorkeeper[word] |= mask
+		}
+	}
+	return seen
+}
+
+func (t *TinyLFU) inDoorkeeper(h uint64) bool {
+	for i := 0; i < 2; i++ {
+		bit := t.index(h, sketchDepth+i)
+		if t.doorkeeper[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reset 老化: 计数减半, 清空 doorkeeper
+func (t *TinyLFU) reset() {
+	t.additions = 0
+	for i := range t.sketch {
+		for j := range t.sketch[i] {
+			t.sketch[i][j] >>= 1
+		}
+	}
+	clear(t.doorkeeper)
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTinyLFU(t *testing.T) {
+	f := NewTinyLFU(100)
+	if f.Estimate("daz") != 0 {
+		t.Fatalf("unseen key should have zero frequency")
+	}
+	f.Record("daz")
+	if f.Estimate("daz") != 1 {
+		t.Fatalf("first access should only be recorded by the doorkeeper")
+	}
+	for i := 0; i < 4; i++ {
+		f.Record("daz")
+	}
+	if n := f.Estimate("daz"); n != 5 {
+		t.Fatalf("expect frequency 5, but %d got", n)
+	}
+	if !f.Admit("daz", "tom") || f.Admit("tom", "daz") {
+		t.Fatalf("frequent key should be admitted over rare one")
+	}
+
+	// 老化后计数减半, doorkeeper 被清空
+	f.reset()
+	if n := f.Estimate("daz"); n != 2 {
+		t.Fatalf("frequency should be halved after reset, but %d got", n)
+	}
+	for i := 0; i < f.sampleSize; i++ {
+		f.Record("daz")
+	}
+	if f.additions != 0 || f.Estimate("daz") > maxFrequency/2+1 {
+		t.Fatalf("sketch should be aged every sampleSize records")
+	}
+}
+
+// scanWorkload 在热点 key 的访问中插入对冷 key 的一次性扫描
+func scanWorkload(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		if i%2 == 0 {
+			keys = append(keys, fmt.Sprintf("hot%d", r.Intn(50)))
+		} else {
+			keys = append(keys, fmt.Sprintf("cold%d", i))
+		}
+	}
+	return keys
+}
+
+// hitRatio 按 trace 访问 Cache, 未命中时加载并添加, 返回命中率
+func hitRatio(c *Cache, trace []string) float64 {
+	hits := 0
+	for _, key := range trace {
+		if _, ok := c.Get(key); ok {
+			hits++
+			continue
+		}
+		c.AddIfAdmitted(key, String("v"), time.Time{}, 0)
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func TestCache_Admission(t *testing.T) {
+	trace := scanWorkload(20000)
+	// 每条记录约 6 字节, 可以容纳 100 条
+	plain := mustNew(Options{MaxBytes: 600})
+	admitted := mustNew(Options{MaxBytes: 600})
+	admitted.Admission = NewTinyLFU(100)
+
+	without, with := hitRatio(plain, trace), hitRatio(admitted, trace)
+	if with <= without || with < 0.4 {
+		t.Fatalf("TinyLFU should protect hot keys from the scan, hit ratio %.3f without, %.3f with", without, with)
+	}
+}
+
+func TestCache_AdmissionWindow(t *testing.T) {
+	c := mustNew(Options{MaxEntries: 100})
+	c.Admission = NewTinyLFU(100)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 100; j++ {
+			key := fmt.Sprintf("hot%d", j)
+			if _, ok := c.Get(key); !ok {
+				c.AddIfAdmitted(key, String("v"), time.Time{}, 0)
+			}
+		}
+	}
+
+	// 新 key 先进入准入窗口, 不会因频率低于淘汰对象而被立即拒绝
+	c.AddIfAdmitted("burst", String("v"), time.Time{}, 0)
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Get("burst"); !ok {
+			t.Fatalf("new key should be kept in the admission window")
+		}
+	}
+	// 离开窗口时, 在窗口中变热的 key 胜过淘汰对象
+	c.AddIfAdmitted("next", String("v"), time.Time{}, 0)
+	if !c.Contains("burst") || !c.Contains("next") || c.Len() != 100 || c.Evictions() != 2 {
+		t.Fatalf("key that became hot in the window should be admitted, %d entries, %d evictions", c.Len(), c.Evictions())
+	}
+	// 只被访问一次的 key 离开窗口时被淘汰
+	c.AddIfAdmitted("cold", String("v"), time.Time{}, 0)
+	if c.Contains("next") || !c.Contains("cold") {
+		t.Fatalf("cold candidate should lose to the victim")
+	}
+}
+
+// burstWorkload 在热点 key 的访问中插入一批批新 key, 每批 key 在短时间内被频繁访问, 之后不再出现
+func burstWorkload(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		if i%2 == 0 {
+			keys = append(keys, fmt.Sprintf("hot%d", r.Intn(50)))
+		} else {
+			keys = append(keys, fmt.Sprintf("burst%d-%d", i/200, r.Intn(4)))
+		}
+	}
+	return keys
+}
+
+func BenchmarkHitRatio(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 1<<16)
+	trace := make([]string, 1<<16)
+	for i := range trace {
+		trace[i] = fmt.Sprint(zipf.Uint64())
+	}
+	for _, tc := range []struct {
+		name     string
+		trace    []string
+		capacity int64
+	}{{"Zipf", trace, 1 << 12}, {"Scan", scanWorkload(1 << 16), 600}, {"Burst", burstWorkload(1 << 16), 1 << 12}} {
+		for _, admission := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/W-TinyLFU=%v", tc.name, admission), func(b *testing.B) {
+				var ratio float64
+				for i := 0; i < b.N; i++ {
+					c := mustNew(Options{MaxBytes: tc.capacity})
+					if admission {
+						c.Admission = NewTinyLFU(int(tc.capacity / 8))
+					}
+					ratio = hitRatio(c, tc.trace)
+				}
+				b.ReportMetric(ratio, "hit-ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package lru
+
+import (
+	"container/list"
+)
+
+/*
+   W-TinyLFU 的准入窗口: https://arxiv.org/abs/1512.00727
+   设置了 Admission 时, AddIfAdmitted 添加的新记录先进入一个按 LRU 淘汰的小窗口, 不经过 EvictionPolicy
+   窗口约占容量的 1/windowRatio, 超出时最久未被访问的记录成为候选: 缓存未满时候选直接进入 EvictionPolicy,
+   已满时由 Admission 比较候选与 EvictionPolicy 选出的淘汰对象, 候选胜出时进入 EvictionPolicy, 淘汰对象被淘汰,
+   否则候选被淘汰; 突然变热的新 key 可以先在窗口中积累访问次数, 而不是一加入就与淘汰对象比较
+*/
+
+// windowRatio 是准入窗口占容量的比例的倒数
+const windowRatio = 100
+
+// enterWindow 将新记录放入准入窗口
+func (c *Cache) enterWindow(kv *entry) {
+	if c.window == nil {
+		c.window = list.New()
+	}
+	kv.window = c.window.PushFront(kv)
+	c.windowBytes += int64(len(kv.key)) + int64(kv.value.Len())
+}
+
+// leaveWindow 将记录移出准入窗口
+func (c *Cache) leaveWindow(kv *entry) {
+	c.window.Remove(kv.window)
+	kv.window = nil
+	c.windowBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+}
+
+// access 记录一次对 kv 的访问
+func (c *Cache) access(kv *entry) {
+	if kv.window != nil {
+		c.window.MoveToFront(kv.window)
+		return
+	}
+	c.policy.Access(kv.key)
+}
+
+// promote 将窗口中的记录交给 EvictionPolicy
+func (c *Cache) promote(kv *entry) {
+	c.leaveWindow(kv)
+	c.policy.Add(kv.key)
+	if p, ok := c.policy.(CostPolicy); ok && kv.cost > 0 {
+		p.SetCost(kv.key, int64(len(kv.key))+int64(kv.value.Len()), kv.cost)
+	}
+}
+
+// windowOverflow 判断准入窗口是否超出其容量, 窗口至少保留最近添加的一条记录
+func (c *Cache) windowOverflow() bool {
+	if c.window == nil || c.window.Len() <= 1 {
+		return false
+	}
+	return c.capacity != 0 && c.windowBytes > c.capacity/windowRatio ||
+		c.maxEntries != 0 && c.window.Len() > c.maxEntries/windowRatio
+}
+
+// drainWindow 处理超出准入窗口容量的记录: 缓存未满时直接交给 EvictionPolicy,
+// 已满时由 Admission 比较候选与淘汰对象, 较少被访问的一方被淘汰
+func (c *Cache) drainWindow() {
+	for c.windowOverflow() {
+		candidate := c.window.Back().Value.(*entry)
+		key, ok := c.policy.Victim()
+		if !ok || !c.full(0, 0) || c.Admission == nil {
+			c.promote(candidate)
+			continue
+		}
+		c.evictions++
+		if c.Admission.Admit(candidate.key, key) {
+			c.promote(candidate)
+			c.remove(c.hashmap[key])
+		} else {
+			c.remove(candidate)
+		}
+	}
+}
+
+// victim 返回下一个应被淘汰的记录, EvictionPolicy 中没有记录时淘汰准入窗口中最久未被访问的记录
+func (c *Cache) victim() *entry {
+	if key, ok := c.policy.Victim(); ok {
+		return c.hashmap[key]
+	}
+	if c.window != nil && c.window.Len() > 0 {
+		return c.window.Back().Value.(*entry)
+	}
+	return nil
+}
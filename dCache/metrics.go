@@ -0,0 +1,204 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+   Metrics: 以 Prometheus 文本格式导出指标
+   https://prometheus.io/docs/instrumenting/exposition_formats/
+*/
+
+// defaultLatencyBuckets 是远端请求耗时直方图的桶边界, 单位为秒, 与 Prometheus 默认值相同
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram 是一个并发安全的累积直方图
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // 每个桶的上界
+	counts  []uint64  // 落入每个桶的次数, 非累积
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// observe 记录一次耗时
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// 第一个上界 >= v 的桶, 超出所有上界时只计入 +Inf
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// write 以 Prometheus 文本格式输出直方图的各个样本
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式导出指标的 http.Handler
+// 包括每个 Group 的缓存指标, singleFlight 进行中的请求数, 每个远端节点的请求耗时以及 server 的 RPC 计数
+func (s *server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	})
+}
+
+func (s *server) writeMetrics(w io.Writer) {
+	groups := s.registry.Groups()
+	type groupMetric struct {
+		name, typ, help string
+		value           func(g *Group, st Stats) float64
+	}
+	groupMetrics := []groupMetric{
+		{"dcache_group_gets_total", "counter", "Get requests received by the group.",
+			func(g *Group, st Stats) float64 { return float64(st.Gets) }},
+		{"dcache_group_cache_hits_total", "counter", "Get requests served from mainCache or hotCache.",
+			func(g *Group, st Stats) float64 { return float64(st.CacheHits) }},
+		{"dcache_group_negative_hits_total", "counter", "Get requests served from the negative cache.",
+			func(g *Group, st Stats) float64 { return float64(st.NegativeHits) }},
+		{"dcache_group_stale_hits_total", "counter", "Get requests served with an expired value.",
+			func(g *Group, st Stats) float64 { return float64(st.StaleHits) }},
+		{"dcache_group_early_refreshes_total", "counter", "Background refreshes triggered before expiry by early refresh.",
+			func(g *Group, st Stats) float64 { return float64(st.EarlyRefreshes) }},
+		{"dcache_group_peer_loads_total", "counter", "Values loaded from peers.",
+			func(g *Group, st Stats) float64 { return float64(st.PeerLoads) }},
+		{"dcache_group_peer_errors_total", "counter", "Failed loads from peers.",
+			func(g *Group, st Stats) float64 { return float64(st.PeerErrors) }},
+		{"dcache_group_local_loads_total", "counter", "Values loaded from the Getter.",
+			func(g *Group, st Stats) float64 { return float64(st.LocalLoads) }},
+		{"dcache_group_local_load_errors_total", "counter", "Failed loads from the Getter.",
+			func(g *Group, st Stats) float64 { return float64(st.LocalLoadErrs) }},
+		{"dcache_group_loads_deduped_total", "counter", "Loads that shared the result of an in-flight singleflight call.",
+			func(g *Group, st Stats) float64 { return float64(st.LoadsDeduped) }},
+		{"dcache_group_pinned_items", "gauge", "Keys pinned in the group.",
+			func(g *Group, st Stats) float64 { return float64(st.PinnedItems) }},
+		{"dcache_group_pinned_bytes", "gauge", "Bytes used by pinned values.",
+			func(g *Group, st Stats) float64 { return float64(st.PinnedBytes) }},
+		{"dcache_group_pinned_max_bytes", "gauge", "Bytes available to pinned values.",
+			func(g *Group, st Stats) float64 { return float64(st.PinnedMaxBytes) }},
+		{"dcache_group_loads_in_flight", "gauge", "Singleflight loads currently in flight.",
+			func(g *Group, st Stats) float64 { return float64(g.loader.InFlight()) }},
+	}
+	stats := make([]Stats, len(groups))
+	for i, g := range groups {
+		stats[i] = g.Stats()
+	}
+	for _, m := range groupMetrics {
+		writeHeader(w, m.name, m.typ, m.help)
+		for i, g := range groups {
+			fmt.Fprintf(w, "%s{group=\"%s\"} %s\n", m.name, escapeLabel(g.name), formatFloat(m.value(g, stats[i])))
+		}
+	}
+
+	// lru 的内存占用与淘汰情况, 按 mainCache / hotCache 区分
+	type cacheSample struct {
+		group, cache            string
+		bytes, items, evictions int64
+	}
+	var caches []cacheSample
+	for _, g := range groups {
+		for _, c := range []struct {
+			name string
+			c    *cache
+		}{{"main", &g.mainCache}, {"hot", &g.hotCache}} {
+			bytes, items, evictions := c.c.stats()
+			caches = append(caches, cacheSample{g.name, c.name, bytes, items, evictions})
+		}
+	}
+	writeHeader(w, "dcache_cache_bytes", "gauge", "Bytes used by the cache.")
+	for _, c := range caches {
+		fmt.Fprintf(w, "dcache_cache_bytes{group=\"%s\",cache=\"%s\"} %d\n", escapeLabel(c.group), c.cache, c.bytes)
+	}
+	writeHeader(w, "dcache_cache_items", "gauge", "Entries held by the cache.")
+	for _, c := range caches {
+		fmt.Fprintf(w, "dcache_cache_items{group=\"%s\",cache=\"%s\"} %d\n", escapeLabel(c.group), c.cache, c.items)
+	}
+	writeHeader(w, "dcache_cache_evictions_total", "counter", "Entries evicted from the cache to free capacity.")
+	for _, c := range caches {
+		fmt.Fprintf(w, "dcache_cache_evictions_total{group=\"%s\",cache=\"%s\"} %d\n", escapeLabel(c.group), c.cache, c.evictions)
+	}
+
+	// 每个远端节点的请求耗时
+	s.mu.Lock()
+	addrs := make([]string, 0, len(s.clients))
+	clients := make(map[string]*client, len(s.clients))
+	for addr, c := range s.clients {
+		if addr != s.addr {
+			addrs = append(addrs, addr)
+			clients[addr] = c
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(addrs)
+	writeHeader(w, "dcache_peer_fetch_duration_seconds", "histogram", "Latency of Fetch RPCs sent to peers.")
+	for _, addr := range addrs {
+		clients[addr].latency.write(w, "dcache_peer_fetch_duration_seconds", "peer=\""+escapeLabel(addr)+"\"")
+	}
+
+	st := s.Stats()
+	for _, m := range []struct {
+		name, help string
+		value      int64
+	}{
+		{"dcache_server_get_requests_total", "Get RPCs received by the server.", st.Gets},
+		{"dcache_server_get_errors_total", "Get RPCs that returned an error.", st.GetErrors},
+		{"dcache_server_batch_get_requests_total", "BatchGet RPCs received by the server.", st.BatchGets},
+		{"dcache_server_set_requests_total", "Set RPCs received by the server.", st.Sets},
+		{"dcache_server_invalidate_requests_total", "Invalidate RPCs received by the server.", st.Invalidates},
+	} {
+		writeHeader(w, m.name, "counter", m.help)
+		fmt.Fprintf(w, "%s %d\n", m.name, m.value)
+	}
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// escapeLabel 按照文本格式的要求转义标签值中的 \, " 与换行
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_MetricsHandler(t *testing.T) {
+	gee := NewGroup("dCacheTestMetrics", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	_, _ = gee.Get("daz")
+	_, _ = gee.Get("daz")
+
+	svr, err := NewServer("localhost:9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers("localhost:9999", "localhost:9998")
+	svr.clients["localhost:9998"].latency.observe(20 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	svr.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE dcache_group_gets_total counter",
+		`dcache_group_gets_total{group="dCacheTestMetrics"} 2`,
+		`dcache_group_cache_hits_total{group="dCacheTestMetrics"} 1`,
+		`dcache_group_loads_in_flight{group="dCacheTestMetrics"} 0`,
+		`dcache_cache_bytes{group="dCacheTestMetrics",cache="main"} 6`,
+		`dcache_peer_fetch_duration_seconds_bucket{peer="localhost:9998",le="0.01"} 0`,
+		`dcache_peer_fetch_duration_seconds_bucket{peer="localhost:9998",le="0.025"} 1`,
+		`dcache_peer_fetch_duration_seconds_count{peer="localhost:9998"} 1`,
+		"dcache_server_get_requests_total 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics does not contain %q", want)
+		}
+	}
+	if strings.Contains(body, `peer="localhost:9999"`) {
+		t.Errorf("metrics should not contain the server itself as a peer")
+	}
+}
@@ -5,6 +5,11 @@
 
 package dCache
 
+import (
+	"context"
+	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
+)
+
 // Picker 定义了获取分布式节点的能力
 type Picker interface {
 	Pick(key string) (peer Fetcher, ok bool)
@@ -14,3 +19,30 @@ type Picker interface {
 type Fetcher interface {
 	Fetch(group string, key string) ([]byte, error)
 }
+
+// FetcherWithContext 定义了携带 context 从远端获取缓存的能力
+// ctx 的截止时间与取消信号会随 gRPC 请求传递到远端节点
+type FetcherWithContext interface {
+	FetchContext(ctx context.Context, in *pb.DCacheRequest) (*pb.DCacheResponse, error)
+}
+
+// Putter 定义了将缓存写入远端节点的能力, 用于把 Group.Set 路由到 key 的所有者
+type Putter interface {
+	Put(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error)
+}
+
+// BatchFetcher 定义了一次请求从远端节点获取多个 key 的能力
+type BatchFetcher interface {
+	BatchFetch(ctx context.Context, in *pb.BatchGetRequest) (*pb.BatchGetResponse, error)
+}
+
+// Invalidator 定义了使远端节点上的缓存失效的能力
+type Invalidator interface {
+	Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error)
+}
+
+// PeerLister 定义了列出全部远端节点 (不包括本节点) 的能力, 用于广播失效
+// 返回值的 key 为节点地址
+type PeerLister interface {
+	Peers() map[string]Fetcher
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+   固定 (pin):
+   被固定的 key 的值保存在独立于 mainCache 与 hotCache 的 pinStore 中, 不会被淘汰, 也不会过期
+   pinStore 有独立的容量, 不占用 cacheBytes; Set, Delete 与失效通知只删除值, key 仍然被固定,
+   下一次 Get 重新加载后再次保存到 pinStore 中; 只有值能放入 pinStore 时 key 才会被固定,
+   新的值超出容量时 key 不再被固定, 值按普通的值缓存
+*/
+
+// ErrPinBudget 表示被固定的值超出了 WithPinnedBytes 设置的容量
+var ErrPinBudget = errors.New("pinned bytes budget exceeded")
+
+// WithPinnedBytes 设置被固定的值可以使用的内存, 与 cacheBytes 相互独立; 默认为 0, 即不能固定任何值
+func WithPinnedBytes(maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.pins.maxBytes = maxBytes
+	}
+}
+
+// pinStore 保存被固定的 key 及其值
+type pinStore struct {
+	mu       sync.RWMutex
+	maxBytes int64
+	nbytes   int64
+	// nvalues 是已保存的值的数量, 没有值时 get 不需要加锁, 以免每次命中都竞争同一把锁
+	nvalues atomic.Int64
+	// values 的 key 是全部被固定的 key, 值尚未加载或已被删除时为 nil
+	values map[string]*ByteView
+}
+
+// pin 固定 key 并保存其值, 返回值是否被保存; 超出容量时返回 ErrPinBudget, key 不会被固定
+func (p *pinStore) pin(key string, value ByteView) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.put(key, value)
+}
+
+func (p *pinStore) unpin(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.values[key]
+	if !ok {
+		return false
+	}
+	p.release(key, v)
+	delete(p.values, key)
+	return true
+}
+
+// release 释放 v 占用的内存, 调用方需持有 p.mu
+func (p *pinStore) release(key string, v *ByteView) {
+	if v != nil {
+		p.nbytes -= int64(len(key) + v.Len())
+		p.nvalues.Add(-1)
+	}
+}
+
+// get 返回被固定的 key 已加载的值
+func (p *pinStore) get(key string) (ByteView, bool) {
+	if p.nvalues.Load() == 0 {
+		return ByteView{}, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if v := p.values[key]; v != nil {
+		return *v, true
+	}
+	return ByteView{}, false
+}
+
+// store 在 key 被固定时保存其值, 返回值是否被保存; 超出容量时返回 ErrPinBudget, 原来的值被删除, key 不再被固定
+func (p *pinStore) store(key string, value ByteView) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.values[key]; !ok {
+		return false, nil
+	}
+	return p.put(key, value)
+}
+
+// put 保存 key 的值, 超出容量时删除 key, 调用方需持有 p.mu
+func (p *pinStore) put(key string, value ByteView) (bool, error) {
+	p.release(key, p.values[key])
+	delete(p.values, key)
+	size := int64(len(key) + value.Len())
+	if p.nbytes+size > p.maxBytes {
+		return false, fmt.Errorf("pin [%s] with %d bytes: %w", key, size, ErrPinBudget)
+	}
+
+	// 被固定的值不会过期
+	value.expireAt, value.stale, value.delta = time.Time{}, false, 0
+	if p.values == nil {
+		p.values = make(map[string]*ByteView)
+	}
+	p.values[key] = &value
+	p.nbytes += size
+	p.nvalues.Add(1)
+	return true, nil
+}
+
+// drop 删除 key 的值, key 仍然被固定
+func (p *pinStore) drop(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.values[key]; ok {
+		p.release(key, v)
+		p.values[key] = nil
+	}
+}
+
+func (p *pinStore) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.values, p.nbytes = nil, 0
+	p.nvalues.Store(0)
+}
+
+// stats 返回被固定的 key 的数量与其值占用的内存
+func (p *pinStore) stats() (items, bytes int64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return int64(len(p.values)), p.nbytes
+}
+
+// Pin 固定 key: 加载其值并保存在独立于 mainCache 的内存中, 之后的 Get 总是从内存返回, 不会被淘汰也不会过期
+// 固定只对本节点有效; Set 与 Delete 仍会使其失效, 下一次 Get 重新加载后再次被固定
+// 加载失败或超出 WithPinnedBytes 设置的容量时返回错误, key 不会被固定
+func (g *Group) Pin(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	value, err := g.GetContext(ctx, key)
+	if err != nil {
+		return err
+	}
+	if value.stale {
+		return fmt.Errorf("failed to pin [%s]: only a stale value is available", key)
+	}
+	_, err = g.pinValue(key, value)
+	return err
+}
+
+// Unpin 取消固定 key 并释放其占用的内存, 之后的 Get 会重新加载; key 没有被固定时返回 false
+func (g *Group) Unpin(key string) bool {
+	return g.pins.unpin(key)
+}
+
+// pinValue 固定 key 并保存其值, 并删除 mainCache 与 hotCache 中的旧副本; 超出容量时 key 不会被固定
+func (g *Group) pinValue(key string, value ByteView) (bool, error) {
+	ok, err := g.pins.pin(key, value)
+	if ok {
+		g.mainCache.delete(key)
+		g.hotCache.delete(key)
+	}
+	return ok, err
+}
+
+// storePinned 在 key 被固定时保存其值, 并删除 mainCache 与 hotCache 中的旧副本
+func (g *Group) storePinned(key string, value ByteView) (bool, error) {
+	ok, err := g.pins.store(key, value)
+	if ok {
+		g.mainCache.delete(key)
+		g.hotCache.delete(key)
+	}
+	return ok, err
+}
+
+// populatePinned 在 key 被固定时保存新加载的值, 返回值是否被保存; 超出容量时值按普通的值缓存
+func (g *Group) populatePinned(key string, value ByteView) bool {
+	ok, err := g.storePinned(key, value)
+	if err != nil {
+		log.Printf("[dCache] %s, caching it unpinned\n", err.Error())
+	}
+	return ok
+}
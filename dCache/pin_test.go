@@ -0,0 +1,119 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroup_Pin(t *testing.T) {
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	getter := EntryGetterFunc(func(ctx context.Context, key string) (Entry, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loads[key]++
+		if key == "big" {
+			return Entry{Value: []byte(strings.Repeat("x", 100))}, nil
+		}
+		// 数据源要求固定 flag 开头的 key
+		return Entry{Value: []byte(key), Pin: strings.HasPrefix(key, "flag")}, nil
+	})
+	ctx := context.Background()
+	g, _ := NewRegistry().NewGroup("pin", 64, getter, WithTTL(20*time.Millisecond), WithPinnedBytes(32))
+
+	if err := g.Pin(ctx, "config"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _ = g.Get("flag1"); g.Stats().PinnedItems != 2 {
+		t.Fatalf("Getter should be able to pin the entry")
+	}
+	// 超出 TTL 并填满 mainCache 后, 被固定的值仍然从内存返回
+	for i := 0; i < 20; i++ {
+		_, _ = g.Get(fmt.Sprintf("key%d", i))
+	}
+	time.Sleep(30 * time.Millisecond)
+	for _, key := range []string{"config", "flag1"} {
+		if view, err := g.Get(key); err != nil || view.String() != key || !view.ExpireAt().IsZero() || loads[key] != 1 {
+			t.Fatalf("pinned %s should never be evicted or expire, loaded %d times", key, loads[key])
+		}
+	}
+	pinned := int64(2*len("config") + 2*len("flag1"))
+	stats := g.Stats()
+	if stats.PinnedBytes != pinned || stats.PinnedMaxBytes != 32 || stats.MainCacheBytes > 64 {
+		t.Fatalf("pinned bytes should be accounted separately, but %+v got", stats)
+	}
+
+	if err := g.Pin(ctx, "big"); !errors.Is(err, ErrPinBudget) || g.Stats().PinnedItems != 2 {
+		t.Fatalf("pinning beyond the budget should fail, but %v got", err)
+	}
+
+	// Set 更新被固定的值, Delete 只删除值, 下一次 Get 重新加载后仍然被固定
+	if err := g.Set(ctx, "config", []byte("new"), SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := g.Get("config"); view.String() != "new" || loads["config"] != 1 {
+		t.Fatalf("Set should update the pinned value, but %s got", view.String())
+	}
+	if _, err := g.Delete(ctx, "config"); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := g.Get("config"); view.String() != "config" || loads["config"] != 2 || g.Stats().PinnedBytes != pinned {
+		t.Fatalf("deleted pinned key should be reloaded and pinned again")
+	}
+
+	if !g.Unpin("config") || g.Unpin("config") {
+		t.Fatalf("Unpin should report whether the key was pinned")
+	}
+	if stats := g.Stats(); stats.PinnedItems != 1 || stats.PinnedBytes != int64(2*len("flag1")) {
+		t.Fatalf("Unpin should release the pinned bytes, but %+v got", stats)
+	}
+	g.Unpin("flag1")
+	if n := g.pins.nvalues.Load(); n != 0 {
+		t.Fatalf("Get should skip the pin store once nothing is pinned, but %d values left", n)
+	}
+}
+
+func TestGroup_PinBudget(t *testing.T) {
+	loads := 0
+	getter := EntryGetterFunc(func(ctx context.Context, key string) (Entry, error) {
+		loads++
+		return Entry{Value: []byte(key), Pin: true}, nil
+	})
+	// 没有设置 WithPinnedBytes 时值按普通的值缓存, key 不会被固定
+	g, _ := NewRegistry().NewGroup("pinBudget", 2<<10, getter)
+	for i := 0; i < 3; i++ {
+		_, _ = g.Get("daz")
+		_, _ = g.Get(fmt.Sprintf("key%d", i))
+	}
+	if stats := g.Stats(); loads != 4 || stats.PinnedItems != 0 || stats.MainCacheItems != 4 {
+		t.Fatalf("values beyond the budget should be cached unpinned, %d loads, %+v", loads, stats)
+	}
+
+	// 容量已满时新的 key 不会被固定, 更新后超出容量的 key 不再被固定
+	ctx := context.Background()
+	g, _ = NewRegistry().NewGroup("pinFull", 2<<10, getter, WithPinnedBytes(8))
+	_, _ = g.Get("key1")
+	_, _ = g.Get("key2")
+	if stats := g.Stats(); stats.PinnedItems != 1 || stats.PinnedBytes != 8 {
+		t.Fatalf("only keys whose value fits should be pinned, but %+v got", stats)
+	}
+	if err := g.Set(ctx, "key1", []byte("too large"), SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := g.Stats(); stats.PinnedItems != 0 || stats.PinnedBytes != 0 {
+		t.Fatalf("key should be unpinned when its new value does not fit, but %+v got", stats)
+	}
+	if view, _ := g.Get("key1"); view.String() != "too large" {
+		t.Fatalf("value that does not fit should be cached unpinned, but %s got", view.String())
+	}
+}
@@ -6,6 +6,7 @@
 package register
 
 import (
+	"context"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/naming/resolver"
 	"google.golang.org/grpc"
@@ -13,12 +14,18 @@ import (
 )
 
 func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+	return EtcdDialContext(context.Background(), c, service)
+}
+
+// EtcdDialContext 与 EtcdDial 相同, 但阻塞等待连接时遵守 ctx 的截止时间
+func EtcdDialContext(ctx context.Context, c *clientv3.Client, service string) (*grpc.ClientConn, error) {
 	etcdResolver, err := resolver.NewBuilder(c)
 	if err != nil {
 		return nil, err
 	}
 
-	return grpc.Dial(
+	return grpc.DialContext(
+		ctx,
 		"etcd:///"+service,
 		grpc.WithResolvers(etcdResolver),
 		grpc.WithBlock(),
@@ -0,0 +1,122 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Registry 管理一组 Group, 不同 Registry 中的 Group 相互独立, 可以重名
+// 同一进程中的多个缓存实例或并行的测试应各自使用一个 Registry
+type Registry struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+}
+
+// DefaultRegistry 是包级别的 NewGroup 与 GetGroup 使用的 Registry, 也是 server 默认使用的 Registry
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry 创建一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// NewGroup 在 r 中创建一个新的 Group 实例, 名称已存在或配置不合法时返回错误
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	if getter == nil {
+		return nil, fmt.Errorf("nil Getter")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[name]; ok {
+		return nil, fmt.Errorf("group %s already exists", name)
+	}
+	g, err := newGroup(name, cacheBytes, getter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.groups[name] = g
+
+	return g, nil
+}
+
+// replaceGroup 在 r 中创建一个新的 Group 实例, 同名的 Group 会被替换并像 RemoveGroup 一样释放, 配置不合法时返回错误
+func (r *Registry) replaceGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	if getter == nil {
+		return nil, fmt.Errorf("nil Getter")
+	}
+	g, err := newGroup(name, cacheBytes, getter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	old, ok := r.groups[name]
+	r.groups[name] = g
+	r.mu.Unlock()
+	if ok {
+		log.Printf("[dCache] group %s already exists, replacing it\n", name)
+		old.release()
+	}
+
+	return g, nil
+}
+
+// GetGroup 返回指定名称的 Group, 不存在时返回 nil
+func (r *Registry) GetGroup(name string) *Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.groups[name]
+}
+
+// RemoveGroup 从 r 中移除指定名称的 Group 并释放其缓存, Group 不存在时返回 false
+// 主动过期的后台任务会被停止; 开启了 write-behind 时会停止后台写入, 并尝试写入队列中剩余的修改
+// 移除后仍持有该 Group 的调用方可以继续使用它, 但缓存需要重新加载
+func (r *Registry) RemoveGroup(name string) bool {
+	r.mu.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	g.release()
+	return true
+}
+
+// release 停止 g 的后台任务并释放其缓存
+func (g *Group) release() {
+	if g.janitor != nil {
+		g.janitor.close()
+	}
+	if g.writer != nil {
+		g.writer.close()
+	}
+	g.pins.clear()
+	g.mainCache.clear()
+	g.hotCache.clear()
+	g.negCache.clear()
+}
+
+// Groups 返回按名称排序的全部 Group
+func (r *Registry) Groups() []*Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	return list
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	r1, r2 := NewRegistry(), NewRegistry()
+	g1, err := r1.NewGroup("scores", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2, err := r2.NewGroup("scores", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.GetGroup("scores") != g1 || r2.GetGroup("scores") != g2 || GetGroup("scores") != nil {
+		t.Fatalf("registries should be independent")
+	}
+
+	if _, err := r1.NewGroup("scores", 2<<10, getter); err == nil {
+		t.Fatalf("duplicate group name should return an error")
+	}
+
+	_, _ = g1.Get("daz")
+	if !r1.RemoveGroup("scores") || r1.RemoveGroup("scores") {
+		t.Fatalf("RemoveGroup should report whether the group existed")
+	}
+	if r1.GetGroup("scores") != nil || g1.Stats().MainCacheItems != 0 {
+		t.Fatalf("removed group should be released")
+	}
+	if _, err := r1.NewGroup("scores", 2<<10, getter); err != nil {
+		t.Fatalf("name of a removed group should be reusable, %v", err)
+	}
+
+	svr, err := NewServer("localhost:9999", WithRegistry(r2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := svr.Get(context.Background(), &pb.DCacheRequest{Group: "scores", Key: "daz"})
+	if err != nil || string(resp.GetValue()) != "daz" {
+		t.Fatalf("server should find groups in its own registry, %v", err)
+	}
+}
+
+func TestNewGroup_Replace(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	defer DefaultRegistry.RemoveGroup("dCacheTestReplace")
+	old := NewGroup("dCacheTestReplace", 2<<10, getter, WithExpiryInterval(time.Millisecond))
+	_, _ = old.Get("daz")
+	g := NewGroup("dCacheTestReplace", 2<<10, getter)
+	if GetGroup("dCacheTestReplace") != g || g == old {
+		t.Fatalf("package-level NewGroup should replace the group with the same name")
+	}
+	select {
+	case <-old.janitor.done:
+	default:
+		t.Fatalf("replaced group should be released")
+	}
+	if old.Stats().MainCacheItems != 0 {
+		t.Fatalf("cache of the replaced group should be released")
+	}
+}
@@ -7,15 +7,23 @@ package dCache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Daz-3ux/dazCache/dCache/consistentHash"
 	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
 	"github.com/Daz-3ux/dazCache/dCache/register"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 /*
@@ -38,16 +46,51 @@ type server struct {
 	mu         sync.Mutex
 	consHash   *consistentHash.Map
 	clients    map[string]*client
+	stats      serverStats
+	registry   *Registry // 处理请求时从中查找 Group
+
+	metricsAddr   string       // 为空时不启动指标服务
+	metricsServer *http.Server // 以 Prometheus 文本格式导出指标
+	snapshotDir   string       // 为空时不保存与恢复 snapshot
+}
+
+// ServerOption 是 NewServer 的可选配置
+type ServerOption func(*server)
+
+// WithRegistry 设置 server 处理请求时使用的 Registry, 默认为 DefaultRegistry
+func WithRegistry(r *Registry) ServerOption {
+	return func(s *server) {
+		s.registry = r
+	}
+}
+
+// WithMetricsAddr 使 Start 在 addr 上启动 HTTP 服务, 通过 /metrics 导出 Prometheus 指标
+func WithMetricsAddr(addr string) ServerOption {
+	return func(s *server) {
+		s.metricsAddr = addr
+	}
+}
+
+// WithSnapshotDir 使 Start 在开始服务前从 dir 中恢复各个 Group 的缓存, Stop 时将缓存保存到 dir
+// 每个 Group 对应一个文件, 只有 Start 时已经创建的 Group 会被恢复
+func WithSnapshotDir(dir string) ServerOption {
+	return func(s *server) {
+		s.snapshotDir = dir
+	}
 }
 
-func NewServer(addr string) (*server, error) {
+func NewServer(addr string, opts ...ServerOption) (*server, error) {
 	if addr == "" {
 		addr = defaultAddr
 	}
 	if !validPeerAddr(addr) {
 		return nil, fmt.Errorf("invalid peer address: %s", addr)
 	}
-	return &server{addr: addr}, nil
+	s := &server{addr: addr, registry: DefaultRegistry}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
 }
 
 func validPeerAddr(addr string) bool {
@@ -67,19 +110,94 @@ func (s *server) Get(ctx context.Context, in *pb.DCacheRequest) (*pb.DCacheRespo
 	resp := &pb.DCacheResponse{}
 
 	log.Printf("[dCache_server %s] recv RPC request - (%s)/(%s)", s.addr, group, key)
+	s.stats.gets.Add(1)
 	if key == "" {
+		s.stats.getErrors.Add(1)
 		return resp, fmt.Errorf("key is empty")
 	}
-	g := GetGroup(group)
+	g := s.registry.GetGroup(group)
 	if g == nil {
+		s.stats.getErrors.Add(1)
 		return resp, fmt.Errorf("group %s not found", group)
 	}
 
-	view, err := g.Get(key)
+	view, err := g.GetContext(ctx, key)
 	if err != nil {
+		s.stats.getErrors.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			return resp, status.Error(codes.NotFound, err.Error())
+		}
+		return resp, err
+	}
+	return newGetResponse(view), nil
+}
+
+// newGetResponse 将 ByteView 转换为 Get 请求的响应, 剩余的生存时间随响应一起返回
+func newGetResponse(view ByteView) *pb.DCacheResponse {
+	return &pb.DCacheResponse{
+		Value: view.ByteSlice(),
+		Stale: view.Stale(),
+		TtlNs: int64(view.ttl()),
+	}
+}
+
+// BatchGet 一次获取多个 key, 每个 key 的错误记录在对应的 BatchGetEntry 中
+func (s *server) BatchGet(ctx context.Context, in *pb.BatchGetRequest) (*pb.BatchGetResponse, error) {
+	group, keys := in.GetGroup(), in.GetKeys()
+
+	log.Printf("[dCache_server %s] recv RPC BatchGet request - (%s)/(%d keys)", s.addr, group, len(keys))
+	s.stats.batchGets.Add(1)
+	g := s.registry.GetGroup(group)
+	if g == nil {
+		return &pb.BatchGetResponse{}, fmt.Errorf("group %s not found", group)
+	}
+
+	return batchResponse(g.GetMany(ctx, keys)), nil
+}
+
+// Set 将缓存值写入本节点, 由 key 的所有者处理, 不会再次路由
+func (s *server) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.SetResponse{}
+
+	log.Printf("[dCache_server %s] recv RPC Set request - (%s)/(%s)", s.addr, group, key)
+	s.stats.sets.Add(1)
+	if key == "" {
+		return resp, fmt.Errorf("key is empty")
+	}
+	g := s.registry.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group %s not found", group)
+	}
+
+	if err := g.setOnOwner(ctx, key, in.GetValue(), time.Duration(in.GetTtlNs())); err != nil {
 		return resp, err
 	}
-	resp.Value = string(view.ByteSlice())
+	return resp, nil
+}
+
+// Invalidate 删除本节点上缓存的值, 不会再次广播; 本节点是所有者时同时从数据源删除
+func (s *server) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.InvalidateResponse{}
+
+	log.Printf("[dCache_server %s] recv RPC Invalidate request - (%s)/(%s)", s.addr, group, key)
+	s.stats.invalidates.Add(1)
+	if key == "" {
+		return resp, fmt.Errorf("key is empty")
+	}
+	g := s.registry.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group %s not found", group)
+	}
+
+	if in.GetOwner() {
+		if err := g.deleteOnOwner(ctx, key); err != nil {
+			return resp, err
+		}
+		return resp, nil
+	}
+	g.deleteCache(key)
 	return resp, nil
 }
 
@@ -109,6 +227,15 @@ func (s *server) Start() error {
 	grpcServer := grpc.NewServer()
 	pb.RegisterGroupCacheServer(grpcServer, s)
 
+	if s.metricsAddr != "" {
+		s.startMetrics()
+	}
+	if s.snapshotDir != "" {
+		if err := s.loadSnapshots(); err != nil {
+			log.Printf("[%s] restore snapshot failed: %v", s.addr, err)
+		}
+	}
+
 	// 注册服务到 etcd
 	go func() {
 		// 创建一个 etcd, 除非错误否则一直运行
@@ -148,7 +275,7 @@ func (s *server) SetPeers(peersAddr ...string) {
 			panic(fmt.Errorf("invalid peer address: %s", addr))
 		}
 		service := fmt.Sprintf("dCache/%s", addr)
-		s.clients[addr] = &client{name: service}
+		s.clients[addr] = &client{name: service, latency: newHistogram(defaultLatencyBuckets)}
 	}
 }
 
@@ -167,6 +294,20 @@ func (s *server) Pick(key string) (Fetcher, bool) {
 	return s.clients[peerAddr], true
 }
 
+// Peers 返回除本节点外的全部远端节点
+func (s *server) Peers() map[string]Fetcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make(map[string]Fetcher, len(s.clients))
+	for addr, c := range s.clients {
+		if addr != s.addr {
+			peers[addr] = c
+		}
+	}
+	return peers
+}
+
 // Stop 停止 dCache 服务
 func (s *server) Stop() {
 	s.mu.Lock()
@@ -177,7 +318,95 @@ func (s *server) Stop() {
 
 	s.stopSignal <- nil // 发送信号,停止 keepalive 信号
 	s.status = false
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Close(); err != nil {
+			log.Printf("[%s] close metrics server failed: %v", s.addr, err)
+		}
+		s.metricsServer = nil
+	}
+	if s.snapshotDir != "" {
+		if err := s.saveSnapshots(); err != nil {
+			log.Printf("[%s] save snapshot failed: %v", s.addr, err)
+		}
+	}
 	s.clients = nil
 	s.consHash = nil
 	s.mu.Unlock()
 }
+
+// startMetrics 启动导出指标的 HTTP 服务, 调用方需持有 s.mu
+func (s *server) startMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	s.metricsServer = &http.Server{Addr: s.metricsAddr, Handler: mux}
+
+	go func(srv *http.Server) {
+		log.Printf("[%s] metrics is serving at %s/metrics", s.addr, s.metricsAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[%s] metrics server failed: %v", s.addr, err)
+		}
+	}(s.metricsServer)
+}
+
+// snapshotPath 返回 Group 对应的 snapshot 文件路径
+func (s *server) snapshotPath(group string) string {
+	return filepath.Join(s.snapshotDir, url.PathEscape(group)+".snapshot")
+}
+
+// loadSnapshots 恢复 registry 中每个 Group 的 snapshot, 不存在的文件会被跳过
+func (s *server) loadSnapshots() error {
+	var errs []error
+	for _, g := range s.registry.Groups() {
+		f, err := os.Open(s.snapshotPath(g.name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := g.Restore(f); err != nil {
+			errs = append(errs, fmt.Errorf("group %s: %w", g.name, err))
+		}
+		_ = f.Close()
+	}
+
+	return errors.Join(errs...)
+}
+
+// saveSnapshots 保存 registry 中每个 Group 的 snapshot, 先写入临时文件再重命名, 以免留下不完整的文件
+func (s *server) saveSnapshots() error {
+	if err := os.MkdirAll(s.snapshotDir, 0o755); err != nil {
+		return err
+	}
+	var errs []error
+	for _, g := range s.registry.Groups() {
+		if err := saveSnapshot(g, s.snapshotPath(g.name)); err != nil {
+			errs = append(errs, fmt.Errorf("group %s: %w", g.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func saveSnapshot(g *Group, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := g.Snapshot(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -6,14 +6,17 @@
 package singleFlight
 
 import (
+	"context"
 	"sync"
 )
 
-// call 实例代表这更在进行中，或已经结束的请求。使用 sync.WaitGroup 锁避免重入。
+// call 实例代表这更在进行中，或已经结束的请求。使用 done 通道通知等待者, 以便等待者可以随 context 提前退出。
 type call struct {
-	wg  sync.WaitGroup // 一个计数信号量，可以用来记录并维护运行的goroutine
-	val interface{}    // 存储任意类型的返回值
-	err error
+	done chan struct{} // 请求结束时关闭
+	val  interface{}   // 存储任意类型的返回值
+	err  error
+	// abandoned 表示 fn 失败时发起请求的调用者的 ctx 已经结束, 错误可能只属于该调用者
+	abandoned bool
 }
 
 // Group 是 singleFlight 的主数据结构，管理不同 key 的请求 (call)
@@ -25,32 +28,57 @@ type Group struct {
 // Do 方法接收一个 key 和一个函数 fn。在函数 fn 被调用的过程中，相同 key 的其它调用都会被阻塞在 Do 方法调用处
 // 确保 fn 只会被调用一次
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
-	g.mu.Lock()
-	if g.m == nil {
-		g.m = make(map[string]*call)
-	}
+	return g.DoContext(context.Background(), key, fn)
+}
 
-	// 如果 m 中存在 key，说明有其它请求正在进行，直接等待
-	if c, ok := g.m[key]; ok {
+// DoContext 与 Do 相同, 但等待其它调用结果的调用者会在 ctx 结束时提前返回 ctx.Err()
+// fn 由发起请求的调用者执行, 需要自行遵守该调用者的 ctx; 如果 fn 失败时该调用者的 ctx 已经结束,
+// 错误只属于该调用者, ctx 仍然有效的等待者会重新发起请求, 而不是共享这个错误
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	for {
+		g.mu.Lock()
+		if g.m == nil {
+			g.m = make(map[string]*call)
+		}
+
+		// 如果 m 中存在 key，说明有其它请求正在进行，直接等待
+		if c, ok := g.m[key]; ok {
+			g.mu.Unlock()
+			// 等待正在进行的请求, 或调用者放弃等待
+			select {
+			case <-c.done:
+				if c.abandoned && ctx.Err() == nil {
+					continue
+				}
+				return c.val, c.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		// 如果 m 中不存在 key，说明是第一次请求，创建一个 call
+		c := &call{done: make(chan struct{})}
+		g.m[key] = c
 		g.mu.Unlock()
-		c.wg.Wait() // 等待正在进行的请求
-		return c.val, c.err
-	}
 
-	// 如果 m 中不存在 key，说明是第一次请求，创建一个 call，并使用 sync.WaitGroup 对 call 的 wg 计数加1
-	c := new(call)
-	c.wg.Add(1)
-	g.m[key] = c
-	g.mu.Unlock()
+		// 调用 fn，进行请求，并更新 call 的 val 和 err
+		c.val, c.err = fn()
+		c.abandoned = c.err != nil && ctx.Err() != nil
+
+		// 先从 g.m 中删除 call 再唤醒等待者, 重新发起请求的等待者不会再次取到这个已结束的 call
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		close(c.done) // 请求结束，唤醒所有等待者
 
-	// 调用 fn，进行请求，并更新 call 的 val 和 err
-	c.val, c.err = fn()
-	c.wg.Done() // 请求结束，计数减1
+		return c.val, c.err
+	}
+}
 
-	// 更新 g.m，删除 key 对应的 call
+// InFlight 返回正在进行中的请求数
+func (g *Group) InFlight() int {
 	g.mu.Lock()
-	delete(g.m, key)
-	g.mu.Unlock()
+	defer g.mu.Unlock()
 
-	return c.val, c.err
+	return len(g.m)
 }
@@ -6,9 +6,11 @@
 package singleFlight
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestDo(t *testing.T) {
@@ -37,3 +39,75 @@ func TestDoErr(t *testing.T) {
 		t.Errorf("unexpected non-nil value %#v", v)
 	}
 }
+
+func TestDoContextCancel(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			return "bar", nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v, err := g.DoContext(ctx, "key", func() (interface{}, error) {
+		t.Errorf("fn should not be called while another call is in flight")
+		return nil, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DoContext error = %v; want context.Canceled", err)
+	}
+	if v != nil {
+		t.Errorf("unexpected non-nil value %#v", v)
+	}
+	close(release)
+}
+
+func TestInFlight(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+	if n := g.InFlight(); n != 1 {
+		t.Errorf("InFlight = %d; want 1", n)
+	}
+	close(release)
+}
+
+func TestDoContextLeaderCanceled(t *testing.T) {
+	var g Group
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		_, _ = g.DoContext(ctx, "key", func() (interface{}, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}()
+	<-started
+
+	// 第一个调用者放弃后, 等待者重新发起请求, 而不是得到第一个调用者的错误
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	v, err := g.DoContext(context.Background(), "key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if err != nil || v != "bar" {
+		t.Errorf("DoContext = %v, %v; want bar", v, err)
+	}
+}
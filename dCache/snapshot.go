@@ -0,0 +1,213 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+/*
+   Snapshot 文件格式, 整数使用 varint 编码:
+   magic "DCSN" | version(1 byte) | group | 生成时间(unix ns) | 条目数 |
+   每个条目: key | value | 剩余生存时间(ns, 0 表示永不过期) | 加载耗时(ns, 0 表示未知, version 2 起) |
+   CRC-32C(4 bytes, big endian), 覆盖之前的全部内容
+   条目从最久未使用的开始排列, 依次添加即可还原 LRU 顺序
+*/
+
+const (
+	snapshotMagic   = "DCSN"
+	snapshotVersion = 2
+	// minSnapshotVersion 是仍可以恢复的最早版本, version 1 没有记录加载耗时
+	minSnapshotVersion = 1
+	// maxSnapshotField 是单个 key 或 value 的最大长度, 防止损坏的文件导致分配过多内存
+	maxSnapshotField = 1 << 30
+)
+
+// ErrBadSnapshot 表示 snapshot 文件格式错误或校验失败
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot 将 mainCache 中未过期的值写入 w, 包括剩余生存时间与 LRU 顺序
+// hotCache 与负缓存中的记录属于其它节点或可以重新获取, 不会写入
+func (g *Group) Snapshot(w io.Writer) error {
+	entries := g.mainCache.entries()
+	now := time.Now()
+
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: io.MultiWriter(bw, crc)}
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion})
+	sw.writeBytes([]byte(g.name))
+	sw.writeVarint(now.UnixNano())
+	sw.writeUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		var ttl time.Duration
+		if !e.expireAt.IsZero() {
+			// 写入期间到期的值至少保留 1ns, 恢复时会被丢弃
+			ttl = max(e.expireAt.Sub(now), 1)
+		}
+		sw.writeBytes([]byte(e.key))
+		sw.writeBytes(e.value.b)
+		sw.writeVarint(int64(ttl))
+		sw.writeVarint(int64(e.value.delta))
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := binary.Write(bw, binary.BigEndian, crc.Sum32()); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Restore 从 r 中读取 Snapshot 写入的值并加入 mainCache
+// 剩余生存时间从生成 snapshot 时开始计算, 期间已过期的值会被丢弃; mainCache 中已存在的 key 保留当前的值
+// 文件在校验通过后才会写入缓存, 出错时缓存保持不变
+func (g *Group) Restore(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(crcTable)}
+	magic := sr.read(len(snapshotMagic) + 1)
+	var version byte
+	if sr.err == nil {
+		if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+			return fmt.Errorf("%w: invalid magic", ErrBadSnapshot)
+		}
+		if version = magic[len(snapshotMagic)]; version < minSnapshotVersion || version > snapshotVersion {
+			return fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, version)
+		}
+	}
+	name := string(sr.readBytes())
+	if sr.err == nil && name != g.name {
+		return fmt.Errorf("%w: snapshot of group %s can not be restored to %s", ErrBadSnapshot, name, g.name)
+	}
+	createdAt := time.Unix(0, sr.readVarint())
+	n := sr.readUvarint()
+
+	var entries []cacheEntry
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		key := string(sr.readBytes())
+		value := sr.readBytes()
+		ttl := time.Duration(sr.readVarint())
+		var delta time.Duration
+		if version >= 2 {
+			delta = time.Duration(sr.readVarint())
+		}
+		var expireAt time.Time
+		if ttl != 0 {
+			expireAt = createdAt.Add(ttl)
+		}
+		entries = append(entries, cacheEntry{key, ByteView{b: value, delta: delta}, expireAt})
+	}
+	if sr.err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, sr.err)
+	}
+	var sum uint32
+	if err := binary.Read(sr.r, binary.BigEndian, &sum); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if sum != sr.crc.Sum32() {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	now := time.Now()
+	fresh := entries[:0]
+	for _, e := range entries {
+		if e.expireAt.IsZero() || now.Before(e.expireAt) {
+			fresh = append(fresh, e)
+		}
+	}
+	g.mainCache.restore(fresh)
+
+	return nil
+}
+
+// snapshotWriter 记录第一个写入错误, 之后的写入不再执行
+type snapshotWriter struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (w *snapshotWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *snapshotWriter) writeUvarint(v uint64) {
+	w.write(w.buf[:binary.PutUvarint(w.buf[:], v)])
+}
+
+func (w *snapshotWriter) writeVarint(v int64) {
+	w.write(w.buf[:binary.PutVarint(w.buf[:], v)])
+}
+
+func (w *snapshotWriter) writeBytes(b []byte) {
+	w.writeUvarint(uint64(len(b)))
+	w.write(b)
+}
+
+// snapshotReader 在读取的同时计算校验和, 记录第一个读取错误
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, r.err = io.ReadFull(r.r, b); r.err != nil {
+		return nil
+	}
+	r.crc.Write(b)
+	return b
+}
+
+func (r *snapshotReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	v, r.err = binary.ReadUvarint(r)
+	return v
+}
+
+func (r *snapshotReader) readVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	var v int64
+	v, r.err = binary.ReadVarint(r)
+	return v
+}
+
+func (r *snapshotReader) readBytes() []byte {
+	n := r.readUvarint()
+	if r.err == nil && n > maxSnapshotField {
+		r.err = fmt.Errorf("field of %d bytes is too large", n)
+	}
+	return r.read(int(n))
+}
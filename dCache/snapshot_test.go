@@ -0,0 +1,105 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroup_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	})
+	r := NewRegistry()
+	src, _ := r.NewGroup("snapshot", 2<<10, getter)
+	for _, key := range []string{"daz", "tom", "sam"} {
+		_, _ = src.Get(key)
+	}
+	_ = src.Set(ctx, "jack", []byte("589"), SetOptions{TTL: time.Hour})
+	_ = src.Set(ctx, "expired", []byte("630"), SetOptions{TTL: time.Millisecond})
+	time.Sleep(2 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	dst, _ := NewRegistry().NewGroup("snapshot", 2<<10, getter)
+	if err := dst.Restore(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range dst.mainCache.entries() {
+		keys = append(keys, e.key)
+	}
+	if !reflect.DeepEqual(keys, []string{"daz", "tom", "sam", "jack"}) {
+		t.Fatalf("LRU order should be restored without expired entries, but %v got", keys)
+	}
+	want, _ := src.mainCache.get("daz")
+	if got, _ := dst.mainCache.get("daz"); got.delta != want.delta {
+		t.Fatalf("load cost should be restored, expect %v, but %v got", want.delta, got.delta)
+	}
+	view, err := dst.Get("jack")
+	if err != nil || view.String() != "589" || time.Until(view.ExpireAt()) > time.Hour || view.ExpireAt().IsZero() {
+		t.Fatalf("value and remaining TTL of jack should be restored")
+	}
+	if loads != 3 {
+		t.Fatalf("restored values should not be loaded again, loads: %d", loads)
+	}
+
+	other, _ := NewRegistry().NewGroup("other", 2<<10, getter)
+	if err := other.Restore(bytes.NewReader(data)); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("snapshot of another group should be rejected")
+	}
+	for _, corrupt := range [][]byte{
+		data[:len(data)-1],
+		append(append([]byte{}, data[:len(data)-5]...), data[len(data)-5]^1, 0, 0, 0, 0),
+		[]byte("dCache"),
+	} {
+		g, _ := NewRegistry().NewGroup("snapshot", 2<<10, getter)
+		if err := g.Restore(bytes.NewReader(corrupt)); !errors.Is(err, ErrBadSnapshot) || len(g.mainCache.entries()) != 0 {
+			t.Fatalf("corrupted snapshot should be rejected without touching the cache, %v", err)
+		}
+	}
+}
+
+func TestServer_Snapshot(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	dir := t.TempDir()
+	r1 := NewRegistry()
+	g1, _ := r1.NewGroup("scores/v1", 2<<10, getter)
+	_, _ = g1.Get("daz")
+	s1, _ := NewServer("localhost:9999", WithRegistry(r1), WithSnapshotDir(dir))
+	if err := s1.saveSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s1.snapshotPath("scores/v1")); err != nil {
+		t.Fatalf("snapshot file should be written, %v", err)
+	}
+
+	r2 := NewRegistry()
+	g2, _ := r2.NewGroup("scores/v1", 2<<10, getter)
+	_, _ = r2.NewGroup("missing", 2<<10, getter)
+	s2, _ := NewServer("localhost:9999", WithRegistry(r2), WithSnapshotDir(dir))
+	if err := s2.loadSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g2.mainCache.get("daz"); !ok {
+		t.Fatalf("value should be restored from the snapshot directory")
+	}
+}
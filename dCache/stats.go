@@ -0,0 +1,96 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import "sync/atomic"
+
+// Stats 是 Group 统计信息的快照
+type Stats struct {
+	Gets           int64 // Get 请求数, 包括远端节点发来的请求
+	CacheHits      int64 // mainCache 或 hotCache 命中数
+	NegativeHits   int64 // 负缓存命中数
+	StaleHits      int64 // 返回了已过期旧值的次数
+	EarlyRefreshes int64 // 命中后在过期前触发后台刷新的次数, 见 WithEarlyRefresh
+	PeerLoads      int64 // 从远端节点加载成功的次数
+	PeerErrors     int64 // 从远端节点加载失败的次数
+	LocalLoads     int64 // 从数据源加载成功的次数
+	LocalLoadErrs  int64 // 从数据源加载失败的次数
+	LoadsDeduped   int64 // 被 singleFlight 合并, 共享了其它调用结果的次数
+	Evictions      int64 // mainCache 中因容量不足被淘汰的条目数, 不包括删除与过期
+	MainCacheBytes int64 // mainCache 已使用的内存
+	MainCacheItems int64 // mainCache 中的条目数
+	PinnedItems    int64 // 被固定的 key 数, 包括值尚未加载的 key
+	PinnedBytes    int64 // 被固定的值使用的内存, 不计入 MainCacheBytes
+	PinnedMaxBytes int64 // 被固定的值可以使用的内存, 见 WithPinnedBytes
+}
+
+// groupStats 是 Group 内部使用的计数器, 可以被并发更新
+type groupStats struct {
+	gets           atomic.Int64
+	cacheHits      atomic.Int64
+	negativeHits   atomic.Int64
+	staleHits      atomic.Int64
+	earlyRefreshes atomic.Int64
+	peerLoads      atomic.Int64
+	peerErrors     atomic.Int64
+	localLoads     atomic.Int64
+	localLoadErrs  atomic.Int64
+	loadsDeduped   atomic.Int64
+}
+
+// Stats 返回 Group 当前的统计信息
+func (g *Group) Stats() Stats {
+	bytes, items, evictions := g.mainCache.stats()
+	pinnedItems, pinnedBytes := g.pins.stats()
+
+	return Stats{
+		Gets:           g.stats.gets.Load(),
+		CacheHits:      g.stats.cacheHits.Load(),
+		NegativeHits:   g.stats.negativeHits.Load(),
+		StaleHits:      g.stats.staleHits.Load(),
+		EarlyRefreshes: g.stats.earlyRefreshes.Load(),
+		PeerLoads:      g.stats.peerLoads.Load(),
+		PeerErrors:     g.stats.peerErrors.Load(),
+		LocalLoads:     g.stats.localLoads.Load(),
+		LocalLoadErrs:  g.stats.localLoadErrs.Load(),
+		LoadsDeduped:   g.stats.loadsDeduped.Load(),
+		Evictions:      evictions,
+		MainCacheBytes: bytes,
+		MainCacheItems: items,
+		PinnedItems:    pinnedItems,
+		PinnedBytes:    pinnedBytes,
+		PinnedMaxBytes: g.pins.maxBytes,
+	}
+}
+
+// ServerStats 是 server 统计信息的快照
+type ServerStats struct {
+	Gets        int64 // 收到的 Get RPC 请求数
+	GetErrors   int64 // 处理失败的 Get RPC 请求数
+	BatchGets   int64 // 收到的 BatchGet RPC 请求数
+	Sets        int64 // 收到的 Set RPC 请求数
+	Invalidates int64 // 收到的 Invalidate RPC 请求数
+}
+
+// serverStats 是 server 内部使用的计数器, 可以被并发更新
+type serverStats struct {
+	gets        atomic.Int64
+	getErrors   atomic.Int64
+	batchGets   atomic.Int64
+	sets        atomic.Int64
+	invalidates atomic.Int64
+}
+
+// Stats 返回 server 当前的统计信息
+func (s *server) Stats() ServerStats {
+	return ServerStats{
+		Gets:        s.stats.gets.Load(),
+		GetErrors:   s.stats.getErrors.Load(),
+		BatchGets:   s.stats.batchGets.Load(),
+		Sets:        s.stats.sets.Load(),
+		Invalidates: s.stats.invalidates.Load(),
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// TypedGroup 是 Group 的类型化封装, 通过 Codec 完成值与字节数组之间的转换
+// 缓存, 节点间传输与 Group 完全相同, 存储的仍然是编码后的字节数组
+type TypedGroup[T any] struct {
+	group   *Group
+	codec   Codec
+	decoded *decodedCache[T] // 解码后的对象, 为 nil 时每次都重新解码
+}
+
+// TypedOption 是 NewTypedGroup 的可选配置
+type TypedOption func(*typedOptions)
+
+type typedOptions struct {
+	decodedEntries int
+}
+
+// WithDecodedCache 在本地保存最多 maxEntries 个解码后的对象, 缓存的字节数组未变化时直接返回该对象
+// 返回的对象会被多个调用方共享, 调用方不应修改它
+func WithDecodedCache(maxEntries int) TypedOption {
+	return func(o *typedOptions) {
+		o.decodedEntries = maxEntries
+	}
+}
+
+// NewTypedGroup 使用 codec 封装 g
+func NewTypedGroup[T any](g *Group, codec Codec, opts ...TypedOption) *TypedGroup[T] {
+	var o typedOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	t := &TypedGroup[T]{group: g, codec: codec}
+	if o.decodedEntries > 0 {
+		t.decoded = newDecodedCache[T](o.decodedEntries)
+	}
+
+	return t
+}
+
+// TypedGetterFunc 将返回 T 的数据源函数转换为 Group 使用的 Getter, 返回值通过 codec 编码
+func TypedGetterFunc[T any](codec Codec, f func(ctx context.Context, key string) (T, error)) GetterWithContextFunc {
+	return func(ctx context.Context, key string) ([]byte, error) {
+		v, err := f(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	}
+}
+
+// Group 返回被封装的 Group
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+// Get 获取 key 对应的值并解码
+func (t *TypedGroup[T]) Get(ctx context.Context, key string) (T, error) {
+	var v T
+	view, err := t.group.GetContext(ctx, key)
+	if err != nil {
+		return v, err
+	}
+
+	if t.decoded != nil {
+		if v, ok := t.decoded.get(key, view); ok {
+			return v, nil
+		}
+	}
+	if err := t.codec.Unmarshal(view.b, &v); err != nil {
+		return v, err
+	}
+	if t.decoded != nil {
+		t.decoded.add(key, view, v)
+	}
+
+	return v, nil
+}
+
+// Set 编码 v 并写入 key 的所有者节点, 参见 Group.Set
+func (t *TypedGroup[T]) Set(ctx context.Context, key string, v T, opts SetOptions) error {
+	b, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if t.decoded != nil {
+		t.decoded.remove(key)
+	}
+
+	return t.group.Set(ctx, key, b, opts)
+}
+
+// Delete 使 key 在整个集群中失效, 参见 Group.Delete
+func (t *TypedGroup[T]) Delete(ctx context.Context, key string) (InvalidateResult, error) {
+	if t.decoded != nil {
+		t.decoded.remove(key)
+	}
+
+	return t.group.Delete(ctx, key)
+}
+
+// decodedCache 是按条目数淘汰的 LRU 缓存, 保存解码后的对象及其来源的字节数组
+// 只有 Group 返回的字节数组与解码时是同一份时才命中, 因此无需感知 Group 中值的更新与失效
+type decodedCache[T any] struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	cache      map[string]*list.Element
+}
+
+type decodedEntry[T any] struct {
+	key   string
+	src   []byte
+	value T
+}
+
+func newDecodedCache[T any](maxEntries int) *decodedCache[T] {
+	return &decodedCache[T]{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		cache:      make(map[string]*list.Element),
+	}
+}
+
+func (c *decodedCache[T]) get(key string, view ByteView) (value T, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ele, ok := c.cache[key]
+	if !ok {
+		return value, false
+	}
+	e := ele.Value.(*decodedEntry[T])
+	if !sameBytes(e.src, view.b) {
+		return value, false
+	}
+	c.ll.MoveToFront(ele)
+
+	return e.value, true
+}
+
+func (c *decodedCache[T]) add(key string, view ByteView, value T) {
+	// 空值无法判断是否为同一份字节数组
+	if len(view.b) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ele, ok := c.cache[key]; ok {
+		c.ll.MoveToFront(ele)
+		e := ele.Value.(*decodedEntry[T])
+		e.src, e.value = view.b, value
+		return
+	}
+	c.cache[key] = c.ll.PushFront(&decodedEntry[T]{key: key, src: view.b, value: value})
+	for c.ll.Len() > c.maxEntries {
+		ele := c.ll.Back()
+		c.ll.Remove(ele)
+		delete(c.cache, ele.Value.(*decodedEntry[T]).key)
+	}
+}
+
+func (c *decodedCache[T]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ele, ok := c.cache[key]; ok {
+		c.ll.Remove(ele)
+		delete(c.cache, key)
+	}
+}
+
+// sameBytes 判断 a 与 b 是否引用同一段底层数组
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
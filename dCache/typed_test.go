@@ -0,0 +1,102 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/Daz-3ux/dazCache/dCache/dCachePB"
+	"testing"
+)
+
+type score struct {
+	Name  string
+	Score int
+}
+
+func TestTypedGroup(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry()
+	for i, codec := range []Codec{JSONCodec{}, GobCodec{}} {
+		loads := 0
+		getter := TypedGetterFunc(codec, func(ctx context.Context, key string) (score, error) {
+			loads++
+			return score{Name: key, Score: 630}, nil
+		})
+		g, err := r.NewGroup(fmt.Sprintf("typed%d", i), 2<<10, getter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tg := NewTypedGroup[score](g, codec)
+
+		for i := 0; i < 2; i++ {
+			if v, err := tg.Get(ctx, "daz"); err != nil || v != (score{"daz", 630}) {
+				t.Fatalf("%T: failed to get typed value, %v %v", codec, v, err)
+			}
+		}
+		if loads != 1 {
+			t.Fatalf("%T: typed value should be cached as bytes, loads: %d", codec, loads)
+		}
+
+		if err := tg.Set(ctx, "daz", score{"daz", 589}, SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := tg.Get(ctx, "daz"); err != nil || v.Score != 589 {
+			t.Fatalf("%T: Set should store the encoded value, %v %v", codec, v, err)
+		}
+	}
+}
+
+func TestTypedGroup_Proto(t *testing.T) {
+	getter := TypedGetterFunc(ProtoCodec{}, func(ctx context.Context, key string) (*pb.DCacheRequest, error) {
+		return &pb.DCacheRequest{Group: "scores", Key: key}, nil
+	})
+	g, err := NewRegistry().NewGroup("typedProto", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := NewTypedGroup[*pb.DCacheRequest](g, ProtoCodec{})
+
+	v, err := tg.Get(context.Background(), "daz")
+	if err != nil || v.GetGroup() != "scores" || v.GetKey() != "daz" {
+		t.Fatalf("failed to get proto value, %v %v", v, err)
+	}
+
+	if _, err := (ProtoCodec{}).Marshal(score{}); err == nil {
+		t.Fatalf("non proto.Message should not be encoded by ProtoCodec")
+	}
+}
+
+func TestTypedGroup_DecodedCache(t *testing.T) {
+	ctx := context.Background()
+	getter := TypedGetterFunc(JSONCodec{}, func(ctx context.Context, key string) (*score, error) {
+		return &score{Name: key}, nil
+	})
+	g, err := NewRegistry().NewGroup("typedDecoded", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := NewTypedGroup[*score](g, JSONCodec{}, WithDecodedCache(1))
+
+	v1, _ := tg.Get(ctx, "daz")
+	v2, _ := tg.Get(ctx, "daz")
+	if v1 == nil || v1 != v2 {
+		t.Fatalf("decoded object should be reused while bytes are unchanged")
+	}
+
+	// 绕过 TypedGroup 更新 Group 中的值, 解码缓存也不应返回旧对象
+	if err := g.Set(ctx, "daz", []byte(`{"Name":"daz","Score":630}`), SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if v3, _ := tg.Get(ctx, "daz"); v3 == v1 || v3.Score != 630 {
+		t.Fatalf("decoded object should be refreshed after the value changed")
+	}
+
+	v4, _ := tg.Get(ctx, "tom")
+	if v5, _ := tg.Get(ctx, "daz"); v5 == v4 || tg.decoded.ll.Len() != 1 {
+		t.Fatalf("decoded cache should hold at most 1 entry")
+	}
+}
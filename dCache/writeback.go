@@ -0,0 +1,411 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+   写入数据源: Group.Set 与 Group.Delete 在 key 的所有者节点上将修改写回数据源
+   write-through: 先同步写入数据源, 成功后再更新缓存
+   write-behind:  先更新缓存并放入有界队列, 由后台批量写入数据源, 失败时重试
+*/
+
+// Setter 定义了将值写回数据源的能力
+type Setter interface {
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+// Deleter 定义了从数据源删除值的能力, 未实现时 Group.Delete 只会使缓存失效
+type Deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// SetterFunc 是一个实现了 Setter 接口的函数类型
+type SetterFunc func(ctx context.Context, key string, value []byte) error
+
+func (f SetterFunc) Set(ctx context.Context, key string, value []byte) error {
+	return f(ctx, key, value)
+}
+
+// Write 是一次待写入数据源的修改
+type Write struct {
+	Key    string
+	Value  []byte
+	Delete bool // 为 true 时从数据源删除 Key, 忽略 Value
+}
+
+// BatchWriter 定义了一次写入多个修改的能力, write-behind 模式下优先使用, 需要同时处理删除
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, writes []Write) error
+}
+
+// ErrWriteQueueFull 表示 write-behind 队列已满, 本次修改没有被接受
+var ErrWriteQueueFull = errors.New("write-behind queue is full")
+
+// WithWriteThrough 开启 write-through: 所有者节点先将修改同步写入 store, 成功后再更新缓存
+// store 实现了 Deleter 时 Group.Delete 也会删除数据源中的值
+func WithWriteThrough(store Setter) GroupOption {
+	return func(g *Group) {
+		g.writer = &writeThrough{store: store}
+	}
+}
+
+// WriteBehindOptions 是 write-behind 的配置, 零值使用默认值
+type WriteBehindOptions struct {
+	QueueSize     int           // 队列中最多等待写入的 key 数, 默认 1024
+	BatchSize     int           // 每批最多写入的修改数, 默认 128; 每次定时写入会分批写入队列中的全部修改
+	FlushInterval time.Duration // 后台写入的间隔, 也是失败后重试的间隔, 默认 1s
+	MaxRetries    int           // 每个修改最多重试的次数, 超过后丢弃, 默认 3
+	WriteTimeout  time.Duration // 每次写入数据源的超时时间, 默认 10s
+}
+
+// WithWriteBehind 开启 write-behind: 所有者节点先更新缓存, 再由后台按批写入 store
+// 同一个 key 的多次修改在写入前会被合并, 只写入最后一次; 队列已满时 Set 与 Delete 返回 ErrWriteQueueFull
+func WithWriteBehind(store Setter, opts WriteBehindOptions) GroupOption {
+	return func(g *Group) {
+		g.writer = newWriteBehind(g.name, store, opts)
+	}
+}
+
+// storeWriter 是 write-through 与 write-behind 的共同接口
+type storeWriter interface {
+	write(ctx context.Context, w Write) error
+	// pending 返回尚未写入数据源的修改, 以免加载到数据源中的旧值
+	pending(key string) (Write, bool)
+	flush(ctx context.Context) error
+	state() WriteBehindState
+	close()
+}
+
+// writeToStore 将一次修改写入 store
+func writeToStore(ctx context.Context, store Setter, w Write) error {
+	if !w.Delete {
+		return store.Set(ctx, w.Key, w.Value)
+	}
+	if d, ok := store.(Deleter); ok {
+		return d.Delete(ctx, w.Key)
+	}
+	return nil
+}
+
+// canDelete 判断 store 是否支持从数据源删除
+func canDelete(store Setter) bool {
+	switch store.(type) {
+	case Deleter, BatchWriter:
+		return true
+	}
+	return false
+}
+
+type writeThrough struct {
+	store Setter
+}
+
+func (w *writeThrough) write(ctx context.Context, wr Write) error {
+	return writeToStore(ctx, w.store, wr)
+}
+
+func (w *writeThrough) pending(string) (Write, bool) { return Write{}, false }
+
+func (w *writeThrough) flush(context.Context) error { return nil }
+
+func (w *writeThrough) state() WriteBehindState { return WriteBehindState{} }
+
+func (w *writeThrough) close() {}
+
+// WriteBehindState 是 write-behind 队列状态的快照
+type WriteBehindState struct {
+	Pending   int       // 等待写入的 key 数
+	Flushed   int64     // 已写入数据源的修改数
+	Retried   int64     // 写入失败后重新排队的次数
+	Dropped   int64     // 超过重试次数被丢弃的修改数
+	Rejected  int64     // 因队列已满被拒绝的修改数
+	LastFlush time.Time // 最近一次写入成功的时间
+	LastError error     // 最近一次写入失败的错误
+}
+
+type writeBehind struct {
+	group string
+	store Setter
+	opts  WriteBehindOptions
+
+	mu       sync.Mutex
+	queue    []string         // 按首次修改的顺序排列的 key
+	writes   map[string]Write // 每个 key 最后一次的修改
+	attempts map[string]int   // 每个 key 已失败的次数
+	st       WriteBehindState // Pending 在 state 中计算
+	flushing sync.Mutex       // 同一时间只有一次写入
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newWriteBehind(group string, store Setter, opts WriteBehindOptions) *writeBehind {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 128
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	w := &writeBehind{
+		group:    group,
+		store:    store,
+		opts:     opts,
+		writes:   make(map[string]Write),
+		attempts: make(map[string]int),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+func (w *writeBehind) write(_ context.Context, wr Write) error {
+	if wr.Delete && !canDelete(w.store) {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.writes[wr.Key]; !ok {
+		if len(w.writes) >= w.opts.QueueSize {
+			w.st.Rejected++
+			return ErrWriteQueueFull
+		}
+		w.queue = append(w.queue, wr.Key)
+	}
+	w.writes[wr.Key] = wr
+	// 新的修改重新计算重试次数
+	delete(w.attempts, wr.Key)
+
+	return nil
+}
+
+func (w *writeBehind) pending(key string) (Write, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wr, ok := w.writes[key]
+	return wr, ok
+}
+
+func (w *writeBehind) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.flushQueued(); err != nil {
+				log.Printf("[dCache] Failed to write behind group %s, %s\n", w.group, err.Error())
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// flushQueued 按 BatchSize 分批写入开始时已在队列中的修改, 直到这些修改全部写入或某一批写入失败
+// 失败的修改重新排队, 等到下一次定时写入; 每一批的超时时间为 WriteTimeout
+func (w *writeBehind) flushQueued() error {
+	w.mu.Lock()
+	n := len(w.queue)
+	w.mu.Unlock()
+
+	for ; n > 0; n -= w.opts.BatchSize {
+		ctx, cancel := context.WithTimeout(context.Background(), w.opts.WriteTimeout)
+		err := w.flushBatch(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush 写入队列中的全部修改, 每个修改只尝试一次, 失败的修改重新排队
+func (w *writeBehind) flush(ctx context.Context) error {
+	w.mu.Lock()
+	n := len(w.queue)
+	w.mu.Unlock()
+
+	var errs []error
+	for n > 0 {
+		if err := w.flushBatch(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		n -= w.opts.BatchSize
+	}
+
+	return errors.Join(errs...)
+}
+
+// flushBatch 取出最多 BatchSize 个修改写入数据源
+func (w *writeBehind) flushBatch(ctx context.Context) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	w.mu.Lock()
+	n := min(len(w.queue), w.opts.BatchSize)
+	if n == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	batch := make([]Write, 0, n)
+	for _, key := range w.queue[:n] {
+		batch = append(batch, w.writes[key])
+	}
+	w.queue = w.queue[n:]
+	w.mu.Unlock()
+
+	var failed map[string]error
+	if bw, ok := w.store.(BatchWriter); ok {
+		if err := bw.WriteBatch(ctx, batch); err != nil {
+			failed = make(map[string]error, len(batch))
+			for _, wr := range batch {
+				failed[wr.Key] = err
+			}
+		}
+	} else {
+		for _, wr := range batch {
+			if err := writeToStore(ctx, w.store, wr); err != nil {
+				if failed == nil {
+					failed = make(map[string]error)
+				}
+				failed[wr.Key] = err
+			}
+		}
+	}
+
+	return w.finish(batch, failed)
+}
+
+// finish 移除已写入的修改, 失败的修改在未超过重试次数时重新排队
+// 写入期间该 key 有了新的修改时, 以新的修改为准, 并将其重新排队
+func (w *writeBehind) finish(batch []Write, failed map[string]error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	for _, wr := range batch {
+		err, ok := failed[wr.Key]
+		if ok {
+			w.st.LastError = err
+		} else {
+			w.st.Flushed++
+			w.st.LastFlush = time.Now()
+		}
+
+		switch {
+		case !sameWrite(w.writes[wr.Key], wr):
+			// key 在写入期间仍保留在 writes 中, 新的修改不会进入队列
+			w.queue = append(w.queue, wr.Key)
+		case !ok:
+			delete(w.writes, wr.Key)
+			delete(w.attempts, wr.Key)
+		case w.attempts[wr.Key] >= w.opts.MaxRetries:
+			w.st.Dropped++
+			delete(w.writes, wr.Key)
+			delete(w.attempts, wr.Key)
+			errs = append(errs, fmt.Errorf("drop write of %s after %d retries: %w", wr.Key, w.opts.MaxRetries, err))
+		default:
+			w.st.Retried++
+			w.attempts[wr.Key]++
+			w.queue = append(w.queue, wr.Key)
+			errs = append(errs, fmt.Errorf("write %s: %w", wr.Key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// sameWrite 判断两次修改是否为同一次, writes 中保存的是同一个 Write 的副本
+func sameWrite(a, b Write) bool {
+	return a.Key == b.Key && a.Delete == b.Delete && len(a.Value) == len(b.Value) &&
+		(len(a.Value) == 0 || &a.Value[0] == &b.Value[0])
+}
+
+func (w *writeBehind) state() WriteBehindState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	st := w.st
+	st.Pending = len(w.writes)
+	return st
+}
+
+// close 停止后台写入, 并尝试写入队列中剩余的修改
+func (w *writeBehind) close() {
+	select {
+	case <-w.stop:
+		return
+	default:
+		close(w.stop)
+	}
+	<-w.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.WriteTimeout)
+	defer cancel()
+	if err := w.flush(ctx); err != nil {
+		log.Printf("[dCache] Failed to write behind group %s on close, %s\n", w.group, err.Error())
+	}
+}
+
+// Flush 立即将 write-behind 队列中的修改写入数据源, 每个修改只尝试一次
+// 没有开启 write-behind 时直接返回
+func (g *Group) Flush(ctx context.Context) error {
+	if g.writer == nil {
+		return nil
+	}
+	return g.writer.flush(ctx)
+}
+
+// WriteBehindState 返回 write-behind 队列的状态, 没有开启 write-behind 时返回零值
+func (g *Group) WriteBehindState() WriteBehindState {
+	if g.writer == nil {
+		return WriteBehindState{}
+	}
+	return g.writer.state()
+}
+
+// setOnOwner 在 key 的所有者节点上处理 Set: 写入数据源后更新本节点的 mainCache
+func (g *Group) setOnOwner(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if g.writer != nil {
+		if err := g.writer.write(ctx, Write{Key: key, Value: cloneBytes(value)}); err != nil {
+			return err
+		}
+	}
+	g.setLocally(key, value, ttl)
+	return nil
+}
+
+// deleteOnOwner 在 key 的所有者节点上处理 Delete: 从数据源删除后使本节点的缓存失效
+func (g *Group) deleteOnOwner(ctx context.Context, key string) error {
+	if g.writer != nil {
+		if err := g.writer.write(ctx, Write{Key: key, Delete: true}); err != nil {
+			return err
+		}
+	}
+	g.deleteCache(key)
+	return nil
+}
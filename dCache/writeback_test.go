@@ -0,0 +1,211 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package dCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore 是一个内存中的数据源, fail 不为 nil 时写入返回该错误
+type testStore struct {
+	mu     sync.Mutex
+	data   map[string]string
+	writes int
+	fail   error
+}
+
+func newTestStore() *testStore {
+	return &testStore{data: map[string]string{"daz": "origin"}}
+}
+
+func (s *testStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.data[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *testStore) Set(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.fail != nil {
+		return s.fail
+	}
+	s.data[key] = string(value)
+	return nil
+}
+
+func (s *testStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.fail != nil {
+		return s.fail
+	}
+	delete(s.data, key)
+	return nil
+}
+
+func (s *testStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func (s *testStore) setFail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = err
+}
+
+func TestGroup_WriteThrough(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	r := NewRegistry()
+	owner, _ := r.NewGroup("writeThroughOwner", 2<<10, GetterFunc(store.Get), WithWriteThrough(store))
+	local, _ := r.NewGroup("writeThroughLocal", 2<<10, GetterFunc(store.Get))
+	local.RegisterPeers(&testPicker{owners: map[string]*testPeer{"remote": {g: owner}}})
+
+	for _, key := range []string{"daz", "remote"} {
+		if err := local.Set(ctx, key, []byte("new"), SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// daz 的所有者是 local, 没有开启 write-through; remote 由 owner 写入数据源
+	if v, _ := store.get("daz"); v != "origin" {
+		t.Fatalf("group without writer should not write the store")
+	}
+	if v, _ := store.get("remote"); v != "new" {
+		t.Fatalf("owner should write the store synchronously")
+	}
+
+	store.setFail(errors.New("db is down"))
+	if err := local.Set(ctx, "remote", []byte("newer"), SetOptions{}); err == nil {
+		t.Fatalf("failed store write should be returned")
+	}
+	if view, _ := owner.Get("remote"); view.String() != "new" {
+		t.Fatalf("cache should not change when the store write fails")
+	}
+	if _, err := local.Delete(ctx, "remote"); err == nil {
+		t.Fatalf("failed store delete should be returned")
+	}
+
+	store.setFail(nil)
+	if _, err := local.Delete(ctx, "remote"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.get("remote"); ok {
+		t.Fatalf("owner should delete the value from the store")
+	}
+	if _, err := local.Get("remote"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted value should not be served, %v", err)
+	}
+}
+
+func TestGroup_WriteBehind(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	r := NewRegistry()
+	g, _ := r.NewGroup("writeBehind", 2<<10, GetterFunc(store.Get),
+		WithWriteBehind(store, WriteBehindOptions{QueueSize: 2, FlushInterval: time.Hour, MaxRetries: 1}))
+
+	for _, v := range []string{"1", "2", "3"} {
+		if err := g.Set(ctx, "daz", []byte(v), SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = g.Set(ctx, "tom", []byte("589"), SetOptions{})
+	if err := g.Set(ctx, "sam", []byte("630"), SetOptions{}); !errors.Is(err, ErrWriteQueueFull) {
+		t.Fatalf("full queue should reject new keys, %v", err)
+	}
+	if st := g.WriteBehindState(); st.Pending != 2 || st.Rejected != 1 {
+		t.Fatalf("unexpected state %+v", st)
+	}
+	if v, _ := store.get("daz"); v != "origin" {
+		t.Fatalf("write-behind should not write the store before flush")
+	}
+
+	// 缓存被清空后仍应读到尚未写入数据源的值
+	g.deleteCache("daz")
+	if view, err := g.Get("daz"); err != nil || view.String() != "3" {
+		t.Fatalf("pending write should be served, but %q got", view.String())
+	}
+
+	store.setFail(errors.New("db is down"))
+	if err := g.Flush(ctx); err == nil {
+		t.Fatalf("failed flush should return an error")
+	}
+	if st := g.WriteBehindState(); st.Pending != 2 || st.Retried != 2 || st.LastError == nil {
+		t.Fatalf("failed writes should be retried, %+v", st)
+	}
+
+	store.setFail(nil)
+	if err := g.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.get("daz"); v != "3" || store.writes != 4 {
+		t.Fatalf("writes of daz should be coalesced, value %s, writes %d", v, store.writes)
+	}
+	if st := g.WriteBehindState(); st.Pending != 0 || st.Flushed != 2 || st.LastFlush.IsZero() {
+		t.Fatalf("unexpected state %+v", st)
+	}
+
+	store.setFail(errors.New("db is down"))
+	_ = g.Set(ctx, "tom", []byte("600"), SetOptions{})
+	_ = g.Flush(ctx)
+	_ = g.Flush(ctx)
+	if st := g.WriteBehindState(); st.Pending != 0 || st.Dropped != 1 {
+		t.Fatalf("write should be dropped after MaxRetries, %+v", st)
+	}
+
+	store.setFail(nil)
+	if _, err := g.Delete(ctx, "daz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("daz"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("pending delete should be served as not found, %v", err)
+	}
+	r.RemoveGroup("writeBehind")
+	if _, ok := store.get("daz"); ok {
+		t.Fatalf("RemoveGroup should flush pending writes")
+	}
+}
+
+func TestGroup_WriteBehindBatches(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	r := NewRegistry()
+	interval := 100 * time.Millisecond
+	start := time.Now()
+	g, _ := r.NewGroup("writeBehindBatches", 2<<10, GetterFunc(store.Get),
+		WithWriteBehind(store, WriteBehindOptions{BatchSize: 4, FlushInterval: interval}))
+	defer r.RemoveGroup("writeBehindBatches")
+
+	for i := 0; i < 20; i++ {
+		if err := g.Set(ctx, fmt.Sprintf("key%d", i), []byte("v"), SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 一次定时写入分批写入队列中的全部修改, 而不是只写入一批
+	for g.WriteBehindState().Pending > 0 {
+		if time.Since(start) > interval*3/2 {
+			t.Fatalf("queued writes should be flushed within one interval, %+v", g.WriteBehindState())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if st := g.WriteBehindState(); st.Flushed != 20 {
+		t.Fatalf("all queued writes should be flushed, %+v", st)
+	}
+}
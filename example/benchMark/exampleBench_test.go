@@ -23,7 +23,7 @@ func BenchmarkGetDazScore(b *testing.B) {
 			if v, ok := mysql[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
+			return nil, fmt.Errorf("%s not exist: %w", key, dCache.ErrNotFound)
 		}))
 
 	_, err := group.Get("daz")
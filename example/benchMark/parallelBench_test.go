@@ -0,0 +1,80 @@
+// Copyright 2023 daz-3ux(Daz) <daz-3ux@proton.me>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file. The original repo for
+// this file is https://github.com/Daz-3ux/dCache.
+
+package benchMark
+
+import (
+	"fmt"
+	"github.com/Daz-3ux/dazCache/dCache"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// newBenchGroup 创建一个容量足以容纳全部 key 的 Group, 并预先加载这些 key
+// 每次命中都会打印日志, 测试期间丢弃日志, 以免 log 的锁掩盖缓存本身的竞争
+func newBenchGroup(b *testing.B, shards int, keys []string) *dCache.Group {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	group, err := dCache.NewRegistry().NewGroup("scores", 64<<20, dCache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), dCache.WithShards(shards))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := group.Get(key); err != nil {
+			b.Fatalf("Error getting value: %s", err)
+		}
+	}
+	return group
+}
+
+// BenchmarkParallelGet 对比不同分区数下并发读取缓存命中的吞吐量
+// 例如: go test -bench ParallelGet -cpu 1,8,32
+func BenchmarkParallelGet(b *testing.B) {
+	keys := make([]string, 1<<14)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("daz%d", i)
+	}
+	for _, shards := range []int{1, 4 * runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			group := newBenchGroup(b, shards, keys)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					if _, err := group.Get(keys[r.Intn(len(keys))]); err != nil {
+						b.Errorf("Error getting value: %s", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkParallelGetHotKey 所有 goroutine 读取同一个 key, 分区无法分散该 key 的竞争
+func BenchmarkParallelGetHotKey(b *testing.B) {
+	for _, shards := range []int{1, 4 * runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			group := newBenchGroup(b, shards, []string{"daz"})
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := group.Get("daz"); err != nil {
+						b.Errorf("Error getting value: %s", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
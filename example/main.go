@@ -10,6 +10,7 @@ import (
 	"github.com/Daz-3ux/dazCache/dCache"
 	"log"
 	"sync"
+	"time"
 )
 
 func main() {
@@ -26,8 +27,10 @@ func main() {
 			if v, ok := mysql[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s not exist: %w", key, dCache.ErrNotFound)
+		}),
+		// 不存在的 key 在 5 秒内不再查询数据库
+		dCache.WithNegativeCache(1<<10, 5*time.Second))
 
 	// 启动一个服务实例
 	var addr string = "localhost:8088"
@@ -25,7 +25,7 @@ func main() {
 			if v, ok := mysql[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
+			return nil, fmt.Errorf("%s not exist: %w", key, dCache.ErrNotFound)
 		}))
 
 	// 启动一个服务实例
@@ -3,10 +3,10 @@ module github.com/Daz-3ux/dazCache
 go 1.21.3
 
 require (
-	github.com/Daz-3ux/dCache v0.0.0-20231028132349-10cfe0323995
 	go.etcd.io/etcd/client/v3 v3.5.10
 	google.golang.org/grpc v1.59.0
 	google.golang.org/protobuf v1.31.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (